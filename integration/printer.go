@@ -21,6 +21,11 @@ const (
 
 var out io.Writer = os.Stdout
 
+// SetOutput directs all messages to the writer instead of stdout
+func SetOutput(w io.Writer) {
+	out = w
+}
+
 // PrettyPrintOk [OK] with formatted string
 func PrettyPrintOk(msg string, a ...interface{}) {
 	printMsg(msg, okType, a...)
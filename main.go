@@ -9,14 +9,22 @@ import (
 
 var mode string
 var debug bool
+var store string
 
 func init() {
 	flag.StringVar(&mode, "mode", "worker", "Mode for the daemon (worker | orchestrator)")
 	flag.BoolVar(&debug, "debug", false, "Increase debugging output")
+	flag.StringVar(&store, "store", pkg.ResultStoreDir(), "Directory of the results store for the commands list | show | delete | trend")
 }
 
 func main() {
 	flag.Parse()
+	if flag.NArg() > 0 && flag.Arg(0) == "fetch" {
+		os.Exit(pkg.FetchCommand(flag.Args()[1:]))
+	}
+	if flag.NArg() > 0 {
+		os.Exit(pkg.StoreCommand(store, flag.Args()))
+	}
 	if !validateParams() {
 		integration.PrettyPrintErr("Failed to parse cmdline args - fatal error - bailing out")
 		os.Exit(1)
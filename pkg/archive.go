@@ -0,0 +1,199 @@
+package pkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The orchestrator serves the result directory of a finished run as tar.gz on its RPC listener, so the raw
+// outputs, the results, the fingerprints in the manifest, the plan and the log come out of the pod at once.
+// The nptests binary downloads and unpacks it with the fetch command.
+
+// serveArchive streams the result directory of a finished run, the current one unless the run parameter names another
+func serveArchive(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("run")
+	if len(id) == 0 {
+		globalLock.Lock()
+		id = currentRun.ID
+		globalLock.Unlock()
+	} else if id == latestLink {
+		id, _ = os.Readlink(filepath.Join(runBaseDir, latestLink))
+	}
+	if len(id) == 0 || filepath.Base(id) != id || strings.HasPrefix(id, ".") {
+		http.Error(w, fmt.Sprintf("invalid run ID %q", id), http.StatusBadRequest)
+		return
+	}
+
+	dir := filepath.Join(runBaseDir, id)
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		http.Error(w, fmt.Sprintf("unknown run %s", id), http.StatusNotFound)
+		return
+	}
+	var manifest types.Manifest
+	if err == nil {
+		err = json.Unmarshal(data, &manifest)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read manifest of run %s: %s", id, err), http.StatusInternalServerError)
+		return
+	}
+	if manifest.Run.Finished.IsZero() {
+		http.Error(w, fmt.Sprintf("run %s has not finished yet", id), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".tar.gz"))
+	if err := writeArchive(w, dir, id); err != nil {
+		integration.PrettyPrintWarn("Failed to send archive of run %s to %s: %s", id, r.RemoteAddr, err)
+		return
+	}
+	integration.PrettyPrintInfo("Sent archive of run %s to %s", id, r.RemoteAddr)
+}
+
+// writeArchive writes the directory as tar.gz with all entries below the prefix, temporary files are left out
+func writeArchive(w io.Writer, dir, prefix string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && (!info.Mode().IsRegular() || strings.HasSuffix(path, ".tmp")) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(prefix, rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil || info.IsDir() {
+			return err
+		}
+
+		fd, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		// The log keeps growing, only the size announced in the header is copied
+		_, err = io.CopyN(tw, fd, header.Size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// FetchCommand downloads the archive of a finished run from the orchestrator and unpacks it into the directory,
+// the current run of the orchestrator and the working directory by default, and returns the exit code of the binary:
+//
+//	fetch <orchestrator host[:port]> [run ID] [directory]
+func FetchCommand(args []string) int {
+	if len(args) < 1 || len(args) > 3 {
+		integration.PrettyPrintErr("usage: fetch <orchestrator host[:port]> [run ID] [directory]")
+		return 1
+	}
+	address, id, dir := args[0], "", "."
+	if len(args) > 1 {
+		id = args[1]
+	}
+	if len(args) > 2 {
+		dir = args[2]
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, rpcServicePort)
+	}
+
+	run, err := fetchArchive("http://"+address+archivePath+"?run="+url.QueryEscape(id), dir)
+	if err != nil {
+		integration.PrettyPrintErr("Failed to fetch results: %s", err)
+		return 1
+	}
+	integration.PrettyPrintOk("Fetched run %s into %s", run, filepath.Join(dir, run))
+	return 0
+}
+
+// fetchArchive downloads and unpacks the archive and returns the run ID it contained
+func fetchArchive(location, dir string) (run string, err error) {
+	resp, err := http.Get(location)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return run, nil
+		}
+		if err != nil {
+			return run, err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if rel, err := filepath.Rel(dir, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return run, fmt.Errorf("invalid archive entry %s", header.Name)
+		}
+		if len(run) == 0 {
+			run = strings.SplitN(strings.TrimPrefix(header.Name, "./"), "/", 2)[0]
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = extractFile(tr, target, os.FileMode(header.Mode).Perm())
+		}
+		if err != nil {
+			return run, err
+		}
+	}
+}
+
+func extractFile(r io.Reader, target string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fd, r); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
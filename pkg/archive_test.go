@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"github.com/mrahbar/k8s-nptest/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServeArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := runBaseDir
+	runBaseDir = filepath.Join(dir, "results")
+	defer func() { runBaseDir = saved }()
+
+	// A finished and a running run, and a manifest next to the runs which must not be reachable
+	for id, finished := range map[string]time.Time{"20261019-080000": time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC), "20261019-100000": {}} {
+		if err := os.MkdirAll(filepath.Join(runBaseDir, id, jobOutputDir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		manifest := types.Manifest{Run: types.RunSummary{ID: id, Finished: finished}}
+		if err := writeJsonFile(filepath.Join(runBaseDir, id, manifestName), manifest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeJsonFile(filepath.Join(dir, manifestName), types.Manifest{Run: types.RunSummary{ID: "outside", Finished: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(runBaseDir, "20261019-080000", jobOutputName(0)), []byte("output of job 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		run    string
+		status int
+	}{
+		{"..", http.StatusBadRequest},
+		{"../results", http.StatusBadRequest},
+		{"../..", http.StatusBadRequest},
+		{"20261019-080000/..", http.StatusBadRequest},
+		{"20261019-080000/../..", http.StatusBadRequest},
+		{"/20261019-080000", http.StatusBadRequest},
+		{".hidden", http.StatusBadRequest},
+		{"20261019-120000", http.StatusNotFound},
+		{"20261019-100000", http.StatusConflict},
+		{"20261019-080000", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		serveArchive(w, httptest.NewRequest("GET", archivePath+"?run="+url.QueryEscape(tt.run), nil))
+		if w.Code != tt.status {
+			t.Errorf("run %q: got status %d, want %d: %s", tt.run, w.Code, tt.status, w.Body)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(serveArchive))
+	defer server.Close()
+	target := filepath.Join(dir, "fetched")
+	run, err := fetchArchive(server.URL+archivePath+"?run=20261019-080000", target)
+	if err != nil || run != "20261019-080000" {
+		t.Fatalf("fetched run %q: %v", run, err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(target, run, jobOutputName(0))); err != nil || string(data) != "output of job 1\n" {
+		t.Errorf("fetched output %q: %v", data, err)
+	}
+	if _, err := fetchArchive(server.URL+archivePath+"?run=..", target); err == nil {
+		t.Error("fetched the parent of the runs")
+	}
+}
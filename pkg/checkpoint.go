@@ -0,0 +1,140 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// The orchestrator persists its progress after every output, so a restarted orchestrator pod continues
+// with the next unfinished job instead of starting the whole run over. The file has to be on a volume
+// which survives the restart of the container.
+
+var checkpointPath string
+
+// resumeCheckpoint restores the finished jobs and the collected data points of the checkpoint, if there is one
+// of the same schedule. Jobs which were running when the checkpoint was written run again.
+func resumeCheckpoint(path string) {
+	checkpointPath = path
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		integration.PrettyPrintWarn("Failed to read checkpoint %s, starting over: %s", path, err)
+		return
+	}
+	var checkpoint types.Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		integration.PrettyPrintWarn("Failed to parse checkpoint %s, starting over: %s", path, err)
+		return
+	}
+	if err := matchCheckpoint(&checkpoint); err != nil {
+		integration.PrettyPrintWarn("Checkpoint %s does not match the schedule, starting over: %s", path, err)
+		return
+	}
+
+	var done int
+	for n, job := range checkpoint.Jobs {
+		if job.Done {
+			jobs[n] = job
+			done++
+		}
+	}
+	dataPoints, dataPointKeys = checkpoint.DataPoints, checkpoint.DataPointKeys
+	if len(checkpoint.Run.ID) > 0 {
+		currentRun = checkpoint.Run
+	}
+	if dataPoints == nil {
+		dataPoints = make(map[string][]types.Point)
+	}
+	integration.PrettyPrintOk("Resuming from checkpoint %s with %d of %d jobs done", path, done, len(jobs))
+}
+
+// matchCheckpoint checks that the checkpoint was written for the same testcases and jobs
+func matchCheckpoint(checkpoint *types.Checkpoint) error {
+	if len(checkpoint.Labels) != len(testcases) || len(checkpoint.Jobs) != len(jobs) {
+		return fmt.Errorf("%d testcases and %d jobs instead of %d and %d", len(checkpoint.Labels), len(checkpoint.Jobs), len(testcases), len(jobs))
+	}
+	for n, label := range checkpoint.Labels {
+		if label != testcases[n].Label {
+			return fmt.Errorf("testcase %d is '%s' instead of '%s'", n+1, label, testcases[n].Label)
+		}
+	}
+	for n, job := range checkpoint.Jobs {
+		if job == nil || job.Testcase != jobs[n].Testcase || job.Params != jobs[n].Params {
+			return fmt.Errorf("job %d differs", n)
+		}
+	}
+	return nil
+}
+
+// checkpointSnapshot is the encoded state of the orchestrator at one point, written without holding globalLock
+type checkpointSnapshot struct {
+	data    []byte
+	version int
+}
+
+var checkpointVersion int     // Version of the latest snapshot, guarded by globalLock
+var checkpointWritten int     // Version of the snapshot on disk, guarded by checkpointLock
+var checkpointLock sync.Mutex // Serializes the writes, so an older snapshot never replaces a newer one
+
+// snapshotCheckpoint encodes the jobs and data points, the caller holds globalLock
+func snapshotCheckpoint() *checkpointSnapshot {
+	if len(checkpointPath) == 0 {
+		return nil
+	}
+	checkpoint := types.Checkpoint{Run: currentRun, Jobs: jobs, DataPoints: dataPoints, DataPointKeys: dataPointKeys}
+	for _, v := range testcases {
+		checkpoint.Labels = append(checkpoint.Labels, v.Label)
+	}
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		integration.PrettyPrintWarn("Failed to marshal checkpoint: %s", err)
+		return nil
+	}
+	checkpointVersion++
+	return &checkpointSnapshot{data: data, version: checkpointVersion}
+}
+
+// writeCheckpoint persists the snapshot unless a newer one was written meanwhile, through a temporary file
+// so a crash never leaves a torn checkpoint. It is called after releasing globalLock.
+func writeCheckpoint(snapshot *checkpointSnapshot) {
+	if snapshot == nil {
+		return
+	}
+	checkpointLock.Lock()
+	defer checkpointLock.Unlock()
+	if snapshot.version <= checkpointWritten {
+		return
+	}
+
+	tmp := checkpointPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, snapshot.data, 0666); err != nil {
+		integration.PrettyPrintWarn("Failed to write checkpoint %s: %s", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, checkpointPath); err != nil {
+		integration.PrettyPrintWarn("Failed to write checkpoint %s: %s", checkpointPath, err)
+		return
+	}
+	checkpointWritten = snapshot.version
+}
+
+// removeCheckpoint drops the checkpoint of a completed run, a restart afterwards starts a new one.
+// The caller holds globalLock, snapshots taken before are not written anymore.
+func removeCheckpoint() {
+	if len(checkpointPath) == 0 {
+		return
+	}
+	checkpointLock.Lock()
+	defer checkpointLock.Unlock()
+	checkpointWritten = checkpointVersion
+	if err := os.Remove(checkpointPath); err != nil && !os.IsNotExist(err) {
+		integration.PrettyPrintWarn("Failed to remove checkpoint %s: %s", checkpointPath, err)
+	}
+}
@@ -0,0 +1,135 @@
+package pkg
+
+import (
+	"encoding/json"
+	"github.com/mrahbar/k8s-nptest/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// checkpointSchedule returns a schedule with a sweep, the first job of every testcase is the first tuple of its sweep
+func checkpointSchedule() []*types.Testcase {
+	return []*types.Testcase{
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "1 iperf TCP", Type: iperfTcpTest,
+			Sweep: []types.Dimension{{Name: types.ParamMSS, Values: []string{"96", "160"}}}},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "2 netperf", Type: netperfTest},
+	}
+}
+
+// useRunState replaces the checkpoint, the run and the data points of the orchestrator for the test
+func useRunState(t *testing.T, dir string) {
+	savedPath, savedRun, savedRunDir := checkpointPath, currentRun, runDir
+	savedPoints, savedKeys, savedPreflight := dataPoints, dataPointKeys, preflightPassed
+	t.Cleanup(func() {
+		checkpointPath, currentRun, runDir = savedPath, savedRun, savedRunDir
+		dataPoints, dataPointKeys, preflightPassed = savedPoints, savedKeys, savedPreflight
+	})
+
+	checkpointPath, runDir = filepath.Join(dir, filepath.Base(checkpointFile)), filepath.Join(dir, "run")
+	currentRun = types.RunSummary{ID: "20261019T080000Z", Started: time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)}
+	dataPoints, dataPointKeys, preflightPassed = make(map[string][]types.Point), nil, true
+	if err := os.MkdirAll(filepath.Join(runDir, jobOutputDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	useRunState(t, dir)
+	source, destination := &types.WorkerState{Worker: "netperf-w1", IP: "10.1.0.1"}, &types.WorkerState{Worker: "netperf-w2", IP: "10.1.0.2"}
+	useSchedule(t, checkpointSchedule(), source, destination)
+
+	// The first job is done, the second was running and wrote part of its output
+	jobs[0].Done, jobs[0].Finished, jobs[0].Assigned[0] = true, true, true
+	registerDataPoint("1 iperf TCP", types.Point{Params: jobs[0].Params, Bandwidth: "9000", Index: 0})
+	jobs[1].Assigned[0], jobs[1].Monitors = true, []string{"netperf-w2"}
+	writeOutputFile(runFile(jobOutputName(0)), "output of job 1\n")
+	writeOutputFile(runFile(jobOutputName(1)), "partial output of job 2\n")
+
+	// An older snapshot written late does not replace the newer one
+	older := snapshotCheckpoint()
+	newer := snapshotCheckpoint()
+	writeCheckpoint(newer)
+	writeCheckpoint(older)
+	if data, err := ioutil.ReadFile(checkpointPath); err != nil || string(data) != string(newer.data) {
+		t.Fatalf("checkpoint is not the newer snapshot: %v", err)
+	}
+	want := struct {
+		run    types.RunSummary
+		points map[string][]types.Point
+		keys   []string
+	}{currentRun, dataPoints, dataPointKeys}
+
+	// The restarted orchestrator starts with a new run and the same schedule
+	currentRun, dataPoints, dataPointKeys = types.RunSummary{ID: "20261019T090000Z"}, make(map[string][]types.Point), nil
+	useSchedule(t, checkpointSchedule(), source, destination)
+	resumeCheckpoint(checkpointPath)
+	if !reflect.DeepEqual(currentRun, want.run) || !reflect.DeepEqual(dataPoints, want.points) || !reflect.DeepEqual(dataPointKeys, want.keys) {
+		t.Errorf("got run %+v and points %+v, want %+v", currentRun, dataPoints, want)
+	}
+	if !jobs[0].Done || !jobs[0].Finished || jobs[1].Assigned[0] || len(jobs[1].Monitors) > 0 {
+		t.Errorf("got jobs %+v and %+v, want the first done and the second to run again", jobs[0], jobs[1])
+	}
+
+	// The job running again starts with an empty output
+	source.Idle, destination.Idle = true, true
+	var reply types.WorkItem
+	if allocateWorkToClient(source, &reply); !reply.IsClientItem || reply.ClientItem.Job != 1 {
+		t.Fatalf("second job not handed out: %+v", reply)
+	}
+	for n, want := range []string{"output of job 1\n", ""} {
+		if data, err := ioutil.ReadFile(runFile(jobOutputName(n))); err != nil || string(data) != want {
+			t.Errorf("output of job %d is %q, want %q: %v", n+1, data, want, err)
+		}
+	}
+
+	removeCheckpoint()
+	writeCheckpoint(newer)
+	if _, err := os.Stat(checkpointPath); !os.IsNotExist(err) {
+		t.Errorf("snapshot written after the checkpoint was removed: %v", err)
+	}
+}
+
+func TestMatchCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	useRunState(t, dir)
+	useSchedule(t, checkpointSchedule())
+	var checkpoint types.Checkpoint
+	if err := json.Unmarshal(snapshotCheckpoint().data, &checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(schedule []*types.Testcase) []*types.Testcase
+		match  bool
+	}{
+		{"same schedule", func(s []*types.Testcase) []*types.Testcase { return s }, true},
+		{"renamed testcase", func(s []*types.Testcase) []*types.Testcase { s[1].Label = "2 netperf renamed"; return s }, false},
+		{"additional testcase", func(s []*types.Testcase) []*types.Testcase { return append(s, s[1]) }, false},
+		{"other sweep values", func(s []*types.Testcase) []*types.Testcase {
+			s[0].Sweep = []types.Dimension{{Name: types.ParamMSS, Values: []string{"96", "224"}}}
+			return s
+		}, false},
+		{"other fixed parameter", func(s []*types.Testcase) []*types.Testcase { s[1].Params.Duration = 30; return s }, false},
+		{"other address family", func(s []*types.Testcase) []*types.Testcase { s[1].Params.Family = types.FamilyIPv6; return s }, false},
+	}
+	for _, tt := range tests {
+		useSchedule(t, tt.change(checkpointSchedule()))
+		if err := matchCheckpoint(&checkpoint); (err == nil) != tt.match {
+			t.Errorf("%s: got %v, want match %v", tt.name, err, tt.match)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Connection setup testers against the TCP echo of the latency server. Both record the time of the
+// TCP handshake in microseconds, so conntrack and kube-proxy problems surface as failed or slow
+// connection setups rather than as a drop in bandwidth.
+
+// connRateClient opens and immediately resets short-lived connections to serverHost for the duration
+// of the job, in closed loop per stream or at a fixed rate of connections per second
+func connRateClient(serverHost, serverPort string, params types.Params) *types.LatencyResult {
+	streams := parallelStreams
+	if params.Streams > 0 {
+		streams = params.Streams
+	}
+	duration := time.Duration(defaultDuration) * time.Second
+	if params.Duration > 0 {
+		duration = time.Duration(params.Duration) * time.Second
+	}
+	address := net.JoinHostPort(serverHost, serverPort)
+
+	return driveRequests("tcp-connect", serverHost, streams, duration, params.QPS, func() (int64, error) {
+		conn, err := net.DialTimeout("tcp", address, nativeDialTimeout)
+		if err != nil {
+			return 0, err
+		}
+		// A reset instead of a regular close keeps the client from running out of ports in TIME_WAIT
+		conn.(*net.TCPConn).SetLinger(0)
+		return 0, conn.Close()
+	})
+}
+
+// connScaleClient opens the given number of concurrent connections to serverHost, holds them idle for
+// the duration of the job and checks with a single byte round trip that every one of them survived.
+// Connections/sec of the result is the rate at which the connections were opened.
+func connScaleClient(serverHost, serverPort string, params types.Params) *types.LatencyResult {
+	streams := parallelStreams
+	if params.Streams > 0 {
+		streams = params.Streams
+	}
+	duration := time.Duration(defaultDuration) * time.Second
+	if params.Duration > 0 {
+		duration = time.Duration(params.Duration) * time.Second
+	}
+	connections := connScaleDefault
+	if params.Connections > 0 {
+		connections = params.Connections
+	}
+	address := net.JoinHostPort(serverHost, serverPort)
+	integration.PrettyPrintInfo("Starting connection scale client on %s to %s with %d connections", clientData.Worker, serverHost, connections)
+
+	result := &types.LatencyResult{Protocol: "tcp-connect", Histogram: types.NewHistogram(latencyHighest, latencyDigits)}
+	var lock sync.Mutex
+	var conns []net.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	start := time.Now()
+	deadline := start.Add(duration)
+	pending := make(chan struct{}, connections)
+	for i := 0; i < connections; i++ {
+		pending <- struct{}{}
+	}
+	close(pending)
+
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range pending {
+				sent := time.Now()
+				conn, err := net.DialTimeout("tcp", address, nativeDialTimeout)
+				elapsed := int64(time.Since(sent) / time.Microsecond)
+
+				lock.Lock()
+				if err != nil {
+					if debug {
+						integration.PrettyPrintDebug("Connection to %s failed: %s", address, err)
+					}
+					result.Errors++
+				} else {
+					result.Histogram.RecordValue(elapsed)
+					result.Requests++
+					conns = append(conns, conn)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	result.Duration = time.Since(start).Seconds()
+	integration.PrettyPrintInfo("Opened %d of %d connections to %s in %.2f sec", result.Requests, connections, serverHost, result.Duration)
+
+	time.Sleep(time.Until(deadline))
+	// All connections share one probe deadline, so silently dropped ones do not add up their timeouts
+	probe := make([]byte, 1)
+	probeDeadline := time.Now().Add(latencyTimeout * time.Second)
+	var alive []net.Conn
+	for _, conn := range conns {
+		conn.SetDeadline(probeDeadline)
+		if _, err := conn.Write(probe); err != nil {
+			result.Errors++
+			continue
+		}
+		alive = append(alive, conn)
+	}
+	for _, conn := range alive {
+		if _, err := io.ReadFull(conn, probe); err != nil {
+			result.Errors++
+		}
+	}
+	return result
+}
@@ -0,0 +1,157 @@
+package pkg
+
+import (
+	"bufio"
+	"github.com/mrahbar/k8s-nptest/types"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The counters of the network stack tell why a job underperformed: drops and errors of the interfaces,
+// retransmits and timeouts of TCP, buffer errors of UDP and the softirqs the packet processing ran in.
+// They are sampled together with the CPU, only the deltas are reported.
+
+// snmpGauges are the values of /proc/net/snmp which are no counters, their deltas mean nothing
+var snmpGauges = []string{"Tcp.RtoAlgorithm", "Tcp.RtoMin", "Tcp.RtoMax", "Tcp.MaxConn", "Tcp.CurrEstab",
+	"Ip.Forwarding", "Ip.DefaultTTL"}
+
+// counterSnapshot holds the cumulative counters of the network namespace and the softirqs of the node
+type counterSnapshot struct {
+	interfaces map[string]types.InterfaceCounters
+	snmp       map[string]int64
+	softirqs   map[string]int64
+}
+
+// sampleCounters reads /proc/net/dev, /proc/net/snmp, /proc/net/netstat and /proc/softirqs, unreadable files are left out
+func sampleCounters() *counterSnapshot {
+	snapshot := &counterSnapshot{interfaces: make(map[string]types.InterfaceCounters), snmp: make(map[string]int64), softirqs: make(map[string]int64)}
+	readNetDev(snapshot.interfaces)
+	readSnmp("/proc/net/snmp", snapshot.snmp)
+	readSnmp("/proc/net/netstat", snapshot.snmp)
+	readSoftirqs(snapshot.softirqs)
+	return snapshot
+}
+
+// counterDeltas returns the counters changed between both snapshots
+func counterDeltas(before, after *counterSnapshot, role string) types.StackCounters {
+	rv := types.StackCounters{Worker: clientData.Worker, Role: role, Interfaces: make(map[string]types.InterfaceCounters),
+		Snmp: make(map[string]int64), SoftIrqs: make(map[string]int64)}
+	for name, a := range after.interfaces {
+		b := before.interfaces[name]
+		rv.Interfaces[name] = types.InterfaceCounters{
+			RxPackets: a.RxPackets - b.RxPackets, TxPackets: a.TxPackets - b.TxPackets,
+			RxErrors: a.RxErrors - b.RxErrors, TxErrors: a.TxErrors - b.TxErrors,
+			RxDrops: a.RxDrops - b.RxDrops, TxDrops: a.TxDrops - b.TxDrops,
+		}
+	}
+	for name, value := range after.snmp {
+		if delta := value - before.snmp[name]; delta != 0 && !containsString(snmpGauges, name) {
+			rv.Snmp[name] = delta
+		}
+	}
+	for name, value := range after.softirqs {
+		if delta := value - before.softirqs[name]; delta != 0 {
+			rv.SoftIrqs[name] = delta
+		}
+	}
+	return rv
+}
+
+// readNetDev parses the counters of every interface except loopback, e.g.
+// "  eth0: 1234 10 0 0 0 0 0 0 5678 12 0 0 0 0 0 0"
+func readNetDev(into map[string]types.InterfaceCounters) {
+	readProcLines("/proc/net/dev", func(line string) {
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			return
+		}
+		name := strings.TrimSpace(line[:colon])
+		fields := strings.Fields(line[colon+1:])
+		if name == "lo" || len(fields) < 12 {
+			return
+		}
+		value := func(i int) int64 {
+			v, _ := strconv.ParseInt(fields[i], 10, 64)
+			return v
+		}
+		into[name] = types.InterfaceCounters{RxPackets: value(1), RxErrors: value(2), RxDrops: value(3),
+			TxPackets: value(9), TxErrors: value(10), TxDrops: value(11)}
+	})
+}
+
+// readSnmp parses the pairs of header and value lines of /proc/net/snmp and /proc/net/netstat into
+// Protocol.Counter entries, e.g. "Tcp: ... RetransSegs ..." followed by "Tcp: ... 42 ..."
+func readSnmp(path string, into map[string]int64) {
+	var header []string
+	readProcLines(path, func(line string) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return
+		}
+		if len(header) == 0 || header[0] != fields[0] {
+			header = fields
+			return
+		}
+		protocol := strings.TrimSuffix(fields[0], ":")
+		for i := 1; i < len(fields) && i < len(header); i++ {
+			if v, err := strconv.ParseInt(fields[i], 10, 64); err == nil {
+				into[protocol+"."+header[i]] = v
+			}
+		}
+		header = nil
+	})
+}
+
+// readSoftirqs sums the softirqs of all CPUs per type, e.g. "NET_RX: 1234 5678"
+func readSoftirqs(into map[string]int64) {
+	readProcLines("/proc/softirqs", func(line string) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], ":") {
+			return
+		}
+		name := strings.TrimSuffix(fields[0], ":")
+		for _, field := range fields[1:] {
+			v, _ := strconv.ParseInt(field, 10, 64)
+			into[name] += v
+		}
+	})
+}
+
+func readProcLines(path string, line func(string)) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line(scanner.Text())
+	}
+}
+
+// roleCounters sums the counter deltas of all workers of the role
+func roleCounters(p types.Point, role string) (total types.InterfaceCounters, snmp map[string]int64, softirqs map[string]int64) {
+	snmp, softirqs = make(map[string]int64), make(map[string]int64)
+	for _, c := range p.Counters {
+		if c.Role != role {
+			continue
+		}
+		t := c.Total()
+		total.RxPackets += t.RxPackets
+		total.TxPackets += t.TxPackets
+		total.RxErrors += t.RxErrors
+		total.TxErrors += t.TxErrors
+		total.RxDrops += t.RxDrops
+		total.TxDrops += t.TxDrops
+		for name, v := range c.Snmp {
+			snmp[name] += v
+		}
+		for name, v := range c.SoftIrqs {
+			softirqs[name] += v
+		}
+	}
+	return
+}
@@ -0,0 +1,281 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A bandwidth is only comparable between CNIs together with the CPU it cost. The source workers sample
+// the CPU of their node around their client items, the destination workers get a monitor item and sample
+// theirs until all outputs of the job came in. Both report the usage together with the deltas of the
+// stack counters with ReceiveUsage, the reports attach it to every data point of the job.
+
+var iperfCpuOutputRegexp = regexp.MustCompile("CPU Utilization: local/\\S+ (\\S+)% \\((\\S+)%u/(\\S+)%s\\), remote/\\S+ (\\S+)% \\((\\S+)%u/(\\S+)%s\\)")
+
+// cpuSnapshot holds the cumulative CPU ticks of the node and of every process visible to the worker
+type cpuSnapshot struct {
+	at        time.Time
+	cores     int
+	total     uint64
+	idle      uint64
+	user      uint64
+	system    uint64
+	softirq   uint64
+	processes map[int]processTicks
+}
+
+type processTicks struct {
+	command string
+	ticks   uint64
+}
+
+// sampleCpu reads /proc/stat and the stat of every process, failures leave the snapshot empty
+func sampleCpu() *cpuSnapshot {
+	snapshot := &cpuSnapshot{at: time.Now(), processes: make(map[int]processTicks)}
+	fd, err := os.Open("/proc/stat")
+	if err != nil {
+		integration.PrettyPrintWarn("Failed to sample CPU: %s", err)
+		return snapshot
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if fields[0] != "cpu" {
+			snapshot.cores++
+			continue
+		}
+		// user nice system idle iowait irq softirq steal, guest time is already part of user
+		var ticks [8]uint64
+		for i := range ticks {
+			if i+1 < len(fields) {
+				ticks[i], _ = strconv.ParseUint(fields[i+1], 10, 64)
+			}
+			snapshot.total += ticks[i]
+		}
+		snapshot.user = ticks[0] + ticks[1]
+		snapshot.system = ticks[2]
+		snapshot.idle = ticks[3] + ticks[4]
+		snapshot.softirq = ticks[6]
+	}
+
+	dirs, _ := ioutil.ReadDir("/proc")
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		if p, ok := readProcessTicks(filepath.Join("/proc", dir.Name(), "stat")); ok {
+			snapshot.processes[pid] = p
+		}
+	}
+	return snapshot
+}
+
+// readProcessTicks returns the command and the user and system ticks of a process including its waited for children
+func readProcessTicks(path string) (processTicks, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return processTicks{}, false
+	}
+	stat := string(data)
+	begin, end := strings.Index(stat, "("), strings.LastIndex(stat, ")")
+	if begin < 0 || end < begin {
+		return processTicks{}, false
+	}
+	// The fields following the command start with the state, utime is the 14th field of the line
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 15 {
+		return processTicks{}, false
+	}
+	rv := processTicks{command: stat[begin+1 : end]}
+	for _, field := range fields[11:15] {
+		ticks, _ := strconv.ParseUint(field, 10, 64)
+		rv.ticks += ticks
+	}
+	return rv, true
+}
+
+// cpuUsage returns the CPU spent between both snapshots
+func cpuUsage(before, after *cpuSnapshot, role string) types.CpuUsage {
+	usage := types.CpuUsage{Worker: clientData.Worker, Role: role, Duration: after.at.Sub(before.at).Seconds(), Cores: after.cores,
+		Processes: make(map[string]float64)}
+	if total := float64(after.total - before.total); total > 0 && after.total > before.total {
+		usage.Busy = 100 - 100*float64(after.idle-before.idle)/total
+		usage.User = 100 * float64(after.user-before.user) / total
+		usage.System = 100 * float64(after.system-before.system) / total
+		usage.SoftIrq = 100 * float64(after.softirq-before.softirq) / total
+	}
+
+	if usage.Duration <= 0 {
+		return usage
+	}
+	for pid, p := range after.processes {
+		ticks := p.ticks
+		if b, ok := before.processes[pid]; ok && b.ticks <= ticks {
+			ticks -= b.ticks
+		}
+		if percent := 100 * float64(ticks) / clockTicks / usage.Duration; percent > 0 {
+			usage.Processes[p.command] += percent
+		}
+	}
+	return usage
+}
+
+// jobSampler samples the CPU and the stack counters of the client items of a work item from the first start till all finished
+type jobSampler struct {
+	once     sync.Once
+	before   *cpuSnapshot
+	counters *counterSnapshot
+}
+
+func (s *jobSampler) start() {
+	s.once.Do(func() {
+		s.counters = sampleCounters()
+		s.before = sampleCpu()
+	})
+}
+
+// report sends the usage since the first start to the orchestrator
+func (s *jobSampler) report(client *rpc.Client, job int, role string) {
+	if s.before == nil {
+		return
+	}
+	usage := types.UsageReport{Job: job, Cpu: cpuUsage(s.before, sampleCpu(), role), Counters: counterDeltas(s.counters, sampleCounters(), role)}
+	integration.PrettyPrintInfo("Job %d kept %.2f of %d cores busy on %s", job, usage.Cpu.BusyCores(), usage.Cpu.Cores, clientData.Worker)
+	var reply int
+	if err := client.Call("NetPerfRpc.ReceiveUsage", usage, &reply); err != nil {
+		integration.PrettyPrintErr("Error reporting usage: %s", err)
+	}
+}
+
+// monitorJob samples the CPU of the destination worker until all outputs of the job came in
+func monitorJob(client *rpc.Client, item types.MonitorWorkItem) {
+	if item.Start == types.StartScheduled {
+		time.Sleep(time.Until(item.StartAt))
+	}
+	var sampler jobSampler
+	sampler.start()
+	var reply int
+	if err := client.Call("NetPerfRpc.WaitJob", item, &reply); err != nil {
+		integration.PrettyPrintErr("Error waiting for job %d: %s", item.Job, err)
+		return
+	}
+	sampler.report(client, item.Job, types.RoleServer)
+}
+
+// WaitJob blocks until all outputs of the job came in, or the job timed out
+func (t *NetPerfRpc) WaitJob(data *types.MonitorWorkItem, reply *int) error {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+
+	if data.Job < 0 || data.Job >= len(jobs) {
+		return fmt.Errorf("unknown job %d", data.Job)
+	}
+	expired := false
+	timer := time.AfterFunc(jobTimeout*time.Second, func() {
+		globalLock.Lock()
+		defer globalLock.Unlock()
+		expired = true
+		workChanged.Broadcast()
+	})
+	defer timer.Stop()
+
+	for !jobs[data.Job].Done && !expired {
+		workChanged.Wait()
+	}
+	return nil
+}
+
+// ReceiveUsage records the CPU usage and the counter deltas of a worker for a job
+func (t *NetPerfRpc) ReceiveUsage(data *types.UsageReport, reply *int) error {
+	globalLock.Lock()
+	if data.Job < 0 || data.Job >= len(jobs) {
+		globalLock.Unlock()
+		integration.PrettyPrintWarn("Dropping usage of worker %s for unknown job %d", data.Cpu.Worker, data.Job)
+		return nil
+	}
+	jobs[data.Job].Cpu = append(jobs[data.Job].Cpu, data.Cpu)
+	jobs[data.Job].Counters = append(jobs[data.Job].Counters, data.Counters)
+	checkpoint := snapshotCheckpoint()
+	globalLock.Unlock()
+
+	writeCheckpoint(checkpoint)
+	return nil
+}
+
+// needsMonitor reports whether the worker is a destination of the job which has not been asked to sample its CPU yet.
+// External servers and the cluster DNS behind the DNS test are out of reach.
+func needsMonitor(testcase *types.Testcase, job *types.Job, worker string) bool {
+	return !testcase.External && testcase.Type != dnsTest && isDestination(testcase, worker) && !containsString(job.Monitors, worker)
+}
+
+// isMonitored reports whether every destination of the job samples its CPU
+func isMonitored(testcase *types.Testcase, job *types.Job) bool {
+	for _, flow := range testcaseFlows(testcase) {
+		if needsMonitor(testcase, job, flow.DestinationNode) {
+			return false
+		}
+	}
+	return true
+}
+
+// attachUsage copies the usage of the jobs into their data points, which are registered before all usages came in
+func attachUsage() {
+	for _, label := range dataPointKeys {
+		points := dataPoints[label]
+		for i := range points {
+			job := jobs[points[i].Index]
+			points[i].Cpu, points[i].IperfCpu, points[i].Counters = job.Cpu, job.IperfCpu, job.Counters
+		}
+	}
+}
+
+// parseIperfCpu parses the CPU utilization of the verbose iperf3 output, the values of cpu_utilization_percent in its JSON output
+func parseIperfCpu(output string) *types.IperfCpu {
+	match := iperfCpuOutputRegexp.FindStringSubmatch(output)
+	if match == nil {
+		return nil
+	}
+	var values [6]float64
+	for i := range values {
+		values[i], _ = strconv.ParseFloat(match[i+1], 64)
+	}
+	return &types.IperfCpu{HostTotal: values[0], HostUser: values[1], HostSystem: values[2],
+		RemoteTotal: values[3], RemoteUser: values[4], RemoteSystem: values[5]}
+}
+
+// roleCores returns the busy cores and the cores spent in softirq of all workers of the role
+func roleCores(p types.Point, role string) (busy, softirq float64) {
+	for _, usage := range p.Cpu {
+		if usage.Role == role {
+			busy += usage.BusyCores()
+			softirq += usage.SoftIrq * float64(usage.Cores) / 100
+		}
+	}
+	return
+}
+
+// perCore returns the Mbits/sec of the point per busy core
+func perCore(p types.Point, cores float64) string {
+	bw, err := strconv.ParseFloat(p.Bandwidth, 64)
+	if err != nil || bw <= 0 || cores == 0 {
+		return defaultBandwithFailed
+	}
+	return fmt.Sprintf("%.2f", bw/cores)
+}
@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/types"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// dnsClient repeatedly resolves the names of the job with the pure Go resolver, which neither caches
+// nor goes through the resolver of the C library. Without a configured server the nameserver and
+// search domains of the worker pod are used, i.e. the cluster DNS.
+func dnsClient(serverHost string, params types.Params) (*types.LatencyResult, *types.DnsResult) {
+	streams := 1
+	if params.Streams > 0 {
+		streams = params.Streams
+	}
+	duration := time.Duration(defaultDuration) * time.Second
+	if params.Duration > 0 {
+		duration = time.Duration(params.Duration) * time.Second
+	}
+	names := []string{serverHost}
+	if len(params.DnsNames) > 0 {
+		names = strings.Split(params.DnsNames, ",")
+	}
+
+	resolver := &net.Resolver{PreferGo: true}
+	dns := &types.DnsResult{Answers: make(map[string][]string)}
+	if len(params.DnsServer) > 0 {
+		dns.Server = params.DnsServer
+		if _, _, err := net.SplitHostPort(dns.Server); err != nil {
+			dns.Server = net.JoinHostPort(dns.Server, dnsServerPort)
+		}
+		resolver.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, dns.Server)
+		}
+	}
+
+	var lock sync.Mutex
+	var next uint64
+	result := driveRequests("dns", strings.Join(names, ","), streams, duration, params.QPS, func() (int64, error) {
+		name := strings.TrimSpace(names[int(atomic.AddUint64(&next, 1)-1)%len(names)])
+		ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout*time.Second)
+		defer cancel()
+		ips, err := resolver.LookupIP(ctx, dnsNetwork(params.Family), name)
+
+		lock.Lock()
+		defer lock.Unlock()
+		dns.Lookups++
+		if err != nil {
+			e, ok := err.(*net.DNSError)
+			switch {
+			case ok && e.IsNotFound:
+				// A negative answer is a completed lookup, its latency is what the non-existent name testcase measures
+				dns.NXDomain++
+				return 0, nil
+			case ok && e.IsTimeout:
+				dns.Timeouts++
+			default:
+				dns.Failures++
+			}
+			return 0, err
+		}
+
+		addresses := make([]string, len(ips))
+		for i, ip := range ips {
+			addresses[i] = ip.String()
+		}
+		sort.Strings(addresses)
+		answer := strings.Join(addresses, ",")
+		seen := dns.Answers[name]
+		if len(seen) > 0 && seen[0] != answer {
+			dns.Inconsistent++
+		}
+		if !containsString(seen, answer) {
+			dns.Answers[name] = append(seen, answer)
+		}
+		return 0, nil
+	})
+	return result, dns
+}
+
+// dnsNetwork restricts lookups to the records of the address family, A and AAAA records if none is given
+func dnsNetwork(family string) string {
+	switch family {
+	case types.FamilyIPv4:
+		return "ip4"
+	case types.FamilyIPv6:
+		return "ip6"
+	}
+	return "ip"
+}
+
+// formatDnsResult renders the lookup outcomes of a DNS test for the raw output file
+func formatDnsResult(dns *types.DnsResult) string {
+	server := dns.Server
+	if len(server) == 0 {
+		server = "default nameserver"
+	}
+	rv := fmt.Sprintf("dns %s: %d lookups, %d nxdomain, %d timeouts, %d failures, %d inconsistent answers\n",
+		server, dns.Lookups, dns.NXDomain, dns.Timeouts, dns.Failures, dns.Inconsistent)
+	for name, answers := range dns.Answers {
+		rv += fmt.Sprintf("      %s: %s\n", name, strings.Join(answers, " | "))
+	}
+	return rv
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/types"
+	"net"
+	"strings"
+)
+
+// expandFamilies runs every testcase once per address family given as comma separated list, e.g. "4,6"
+// on dual-stack clusters. The copies follow each other and carry the family in their label, so the
+// results of both paths end up side by side. Without families the testcases use the primary addresses.
+func expandFamilies(testcases []*types.Testcase, families string) ([]*types.Testcase, error) {
+	if len(strings.TrimSpace(families)) == 0 {
+		return testcases, nil
+	}
+
+	var params types.Params
+	var values []string
+	for _, family := range strings.Split(families, ",") {
+		family = strings.TrimSpace(family)
+		if err := params.Set(types.ParamFamily, family); err != nil {
+			return nil, err
+		}
+		values = append(values, family)
+	}
+
+	var rv []*types.Testcase
+	for _, testcase := range testcases {
+		for _, family := range values {
+			clone := *testcase
+			clone.Params.Family = family
+			clone.Label = fmt.Sprintf("%s (%s)", testcase.Label, strings.TrimSpace(familyName(family)))
+			rv = append(rv, &clone)
+		}
+	}
+	return rv, nil
+}
+
+// pickAddress returns the first address of the family, the first address at all if no family is given
+func pickAddress(addresses []string, family string) string {
+	for _, address := range addresses {
+		if len(family) == 0 || addressFamily(address) == family {
+			return address
+		}
+	}
+	return ""
+}
+
+// addressFamily returns the family of an IP address, empty for anything else than an IP address
+func addressFamily(address string) string {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return types.FamilyIPv4
+	default:
+		return types.FamilyIPv6
+	}
+}
+
+// familyName returns the name of the family followed by a space for messages, nothing if no family is given
+func familyName(family string) string {
+	if len(family) == 0 {
+		return ""
+	}
+	return "IPv" + family + " "
+}
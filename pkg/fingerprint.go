@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fingerprintSysctls are the sysctls which change the outcome of the tests, read from /proc/sys
+var fingerprintSysctls = []string{
+	"net.ipv4.tcp_rmem",
+	"net.ipv4.tcp_wmem",
+	"net.ipv4.tcp_congestion_control",
+	"net.core.rmem_max",
+	"net.core.wmem_max",
+	"net.netfilter.nf_conntrack_max",
+}
+
+// collectFingerprint gathers the environment of the worker, anything unreadable is left empty
+func collectFingerprint(ip string) types.Fingerprint {
+	fp := types.Fingerprint{Kernel: readProcValue("/proc/sys/kernel/osrelease"), Sysctls: make(map[string]string)}
+
+	if fd, err := os.Open("/proc/cpuinfo"); err == nil {
+		scanner := bufio.NewScanner(fd)
+		for scanner.Scan() {
+			parts := strings.SplitN(scanner.Text(), ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			switch strings.TrimSpace(parts[0]) {
+			case "processor":
+				fp.Cpus++
+			case "model name":
+				fp.CpuModel = strings.TrimSpace(parts[1])
+			}
+		}
+		fd.Close()
+	}
+
+	fp.Interface = primaryInterface(ip)
+	if len(fp.Interface) > 0 {
+		fp.MTU, _ = strconv.Atoi(readProcValue(filepath.Join("/sys/class/net", fp.Interface, "mtu")))
+		fp.Driver = interfaceDriver(fp.Interface)
+	}
+
+	for _, name := range fingerprintSysctls {
+		if value := readProcValue(filepath.Join("/proc/sys", strings.Replace(name, ".", "/", -1))); len(value) > 0 {
+			fp.Sysctls[name] = strings.Join(strings.Fields(value), " ")
+		}
+	}
+
+	if output, ok := cmdExec(iperf3Path, []string{"--version"}); ok {
+		fp.Iperf3 = firstLine(output)
+	}
+	if output, ok := cmdExec(netperfPath, []string{"-V"}); ok {
+		fp.Netperf = firstLine(output)
+	}
+	return fp
+}
+
+// primaryInterface returns the interface carrying the IP, the first non-loopback interface which is up otherwise
+func primaryInterface(ip string) string {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	var fallback string
+	for _, i := range interfaces {
+		if i.Flags&net.FlagLoopback != 0 || i.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if len(fallback) == 0 {
+			fallback = i.Name
+		}
+		addresses, _ := i.Addrs()
+		for _, address := range addresses {
+			if network, ok := address.(*net.IPNet); ok && network.IP.String() == ip {
+				return i.Name
+			}
+		}
+	}
+	return fallback
+}
+
+// interfaceDriver returns the driver bound to the device of the interface, virtual for software devices like veth
+func interfaceDriver(name string) string {
+	if driver, err := os.Readlink(filepath.Join("/sys/class/net", name, "device", "driver")); err == nil {
+		return filepath.Base(driver)
+	}
+	if device, err := os.Readlink(filepath.Join("/sys/class/net", name)); err == nil && strings.Contains(device, "/virtual/") {
+		return "virtual"
+	}
+	return ""
+}
+
+func readProcValue(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func firstLine(output string) string {
+	return strings.TrimSpace(strings.SplitN(strings.TrimSpace(output), "\n", 2)[0])
+}
+
+// fingerprintDifferences lists the fields in which the fingerprints of two workers differ
+func fingerprintDifferences(a, b types.Fingerprint) (rv []string) {
+	compare := func(field, x, y string) {
+		if x != y {
+			rv = append(rv, fmt.Sprintf("%s %q vs %q", field, x, y))
+		}
+	}
+	compare("kernel", a.Kernel, b.Kernel)
+	compare("CPU model", a.CpuModel, b.CpuModel)
+	compare("CPUs", strconv.Itoa(a.Cpus), strconv.Itoa(b.Cpus))
+	compare("driver", a.Driver, b.Driver)
+	compare("MTU", strconv.Itoa(a.MTU), strconv.Itoa(b.MTU))
+	for _, name := range fingerprintSysctls {
+		compare(name, a.Sysctls[name], b.Sysctls[name])
+	}
+	compare("iperf3", a.Iperf3, b.Iperf3)
+	compare("netperf", a.Netperf, b.Netperf)
+	return
+}
+
+// checkFingerprints warns about every registered worker the new one is paired with in a testcase and which measures in a different environment
+func checkFingerprints(state *types.WorkerState) {
+	peers := make(map[string]bool)
+	for _, v := range testcases {
+		if v.External {
+			continue
+		}
+		for _, flow := range testcaseFlows(v) {
+			switch state.Worker {
+			case flow.SourceNode:
+				peers[flow.DestinationNode] = true
+			case flow.DestinationNode:
+				peers[flow.SourceNode] = true
+			}
+		}
+	}
+
+	var names []string
+	for name := range peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		peer, ok := workerStateMap[name]
+		if !ok || name == state.Worker {
+			continue
+		}
+		if differences := fingerprintDifferences(peer.Fingerprint, state.Fingerprint); len(differences) > 0 {
+			integration.PrettyPrintWarn("Workers %s and %s measure in different environments: %s", peer.Worker, state.Worker, strings.Join(differences, ", "))
+		}
+	}
+}
+
+// testcaseEnvironments returns the fingerprints of the registered workers of the testcase by worker name
+func testcaseEnvironments(testcase *types.Testcase) map[string]types.Fingerprint {
+	rv := make(map[string]types.Fingerprint)
+	for _, flow := range testcaseFlows(testcase) {
+		for _, name := range []string{flow.SourceNode, flow.DestinationNode} {
+			if state, ok := workerStateMap[name]; ok {
+				rv[name] = state.Fingerprint
+			}
+		}
+	}
+	return rv
+}
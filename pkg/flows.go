@@ -0,0 +1,190 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"strconv"
+	"time"
+)
+
+// Multi-flow testcases run several flows at the same time, either many sources to one destination
+// (incast) or one source to many destinations (fan-out). Every flow is handed out to its source worker
+// as a separate client item, the data point of the job is registered once all flows reported back.
+
+// testcaseFlows returns the flows of a testcase, a single flow for the plain source to destination testcases
+func testcaseFlows(testcase *types.Testcase) []types.Flow {
+	if len(testcase.Flows) > 0 {
+		return testcase.Flows
+	}
+	return []types.Flow{{SourceNode: testcase.SourceNode, DestinationNode: testcase.DestinationNode}}
+}
+
+// isMultiFlow reports whether the testcase runs more than a single flow at a time
+func isMultiFlow(testcase *types.Testcase) bool {
+	return len(testcase.Flows) > 0
+}
+
+// isDestination reports whether any flow of the testcase targets the worker
+func isDestination(testcase *types.Testcase, worker string) bool {
+	for _, flow := range testcaseFlows(testcase) {
+		if flow.DestinationNode == worker {
+			return true
+		}
+	}
+	return false
+}
+
+// isStarted reports whether any flow of the job has been handed out or any destination samples its CPU for it
+func isStarted(job *types.Job) bool {
+	if len(job.Monitors) > 0 {
+		return true
+	}
+	for _, assigned := range job.Assigned {
+		if assigned {
+			return true
+		}
+	}
+	return false
+}
+
+// isAssigned reports whether all flows of the job have been handed out
+func isAssigned(job *types.Job) bool {
+	for _, assigned := range job.Assigned {
+		if !assigned {
+			return false
+		}
+	}
+	return true
+}
+
+// destinationSlot returns the number of flows before flow k with the same destination
+func destinationSlot(flows []types.Flow, k int) (rv int) {
+	for _, flow := range flows[:k] {
+		if flow.DestinationNode == flows[k].DestinationNode {
+			rv++
+		}
+	}
+	return
+}
+
+// validateFlows checks that a multi-flow testcase only uses test types and servers which support concurrent flows
+func validateFlows(testcase *types.Testcase) error {
+	if !isMultiFlow(testcase) {
+		if len(testcase.Start) > 0 {
+			return fmt.Errorf("testcase '%s': a coordinated start needs multiple flows", testcase.Label)
+		}
+		return nil
+	}
+	if len(testcase.Start) > 0 && testcase.Start != types.StartScheduled && testcase.Start != types.StartBarrier {
+		return fmt.Errorf("testcase '%s': unknown start %q", testcase.Label, testcase.Start)
+	}
+	switch testcase.Type {
+	case iperfTcpTest, iperfUdpTest, nativeTcpTest, nativeUdpTest, netperfTest:
+	default:
+		return fmt.Errorf("testcase '%s': multiple flows are only supported for throughput tests", testcase.Label)
+	}
+
+	bidir := testcase.Params.Direction == types.DirectionBidir
+	for _, dimension := range testcase.Sweep {
+		for _, value := range dimension.Values {
+			bidir = bidir || dimension.Name == types.ParamDirection && value == types.DirectionBidir
+		}
+	}
+	if bidir {
+		return fmt.Errorf("testcase '%s': multiple flows do not support the %s direction", testcase.Label, types.DirectionBidir)
+	}
+
+	for k := range testcase.Flows {
+		if destinationSlot(testcase.Flows, k) >= iperf3MaxFlows {
+			return fmt.Errorf("testcase '%s': more than %d flows to %s", testcase.Label, iperf3MaxFlows, testcase.Flows[k].DestinationNode)
+		}
+	}
+	return nil
+}
+
+// receiveFlowOutput records the output of a single flow and registers the data point of the job once all flows are in
+func receiveFlowOutput(data *types.WorkerOutput, index int) {
+	job := jobs[index]
+	testcase := testcases[job.Testcase]
+	if data.Flow < 0 || data.Flow >= len(job.Flows) {
+		integration.PrettyPrintWarn("Dropping output of worker %s for unknown flow %d of job %d", data.Worker, data.Flow, index)
+		return
+	}
+	flow := &job.Flows[data.Flow]
+
+	outputLog := fmt.Sprintln("Received output of flow", data.Flow+1, "of", len(job.Flows), "from worker", data.Worker, "for test", testcase.Label,
+		"from", flow.SourceNode, "to", flow.DestinationNode, job.Params.Format(testcase.Sweep)) + data.Output
+	writeOutputFile(runFile(jobOutputName(index)), outputLog)
+	flow.Bandwidth = parseFlowBandwidth(data)
+	flow.StartedAt = data.StartedAt
+	flow.Reported = true
+	integration.PrettyPrintInfo("Flow done from worker %s Bandwidth was %s Mbits/sec", data.Worker, flow.Bandwidth)
+
+	var aggregate float64
+	var first, last time.Time
+	bandwidths := make([]float64, len(job.Flows))
+	for i, f := range job.Flows {
+		if !f.Reported {
+			return
+		}
+		if first.IsZero() || f.StartedAt.Before(first) {
+			first = f.StartedAt
+		}
+		if f.StartedAt.After(last) {
+			last = f.StartedAt
+		}
+		if bw, err := strconv.ParseFloat(f.Bandwidth, 64); err == nil && bw > 0 {
+			bandwidths[i] = bw
+			aggregate += bw
+		}
+	}
+
+	job.Done = true
+	direction := types.DirectionUpload
+	if len(job.Params.Direction) > 0 {
+		direction = job.Params.Direction
+	}
+	point := types.Point{Params: job.Params, Direction: direction, Index: index, Bandwidth: fmt.Sprintf("%.2f", aggregate),
+		Fairness: fmt.Sprintf("%.3f", jainFairness(bandwidths)), StartSkew: fmt.Sprintf("%.1f", last.Sub(first).Seconds()*1000),
+		Flows: append([]types.FlowResult(nil), job.Flows...)}
+	registerDataPoint(testcase.Label, point)
+	integration.PrettyPrintInfo("Job done, aggregate Bandwidth was %s Mbits/sec, fairness was %s, start skew was %s ms", point.Bandwidth, point.Fairness, point.StartSkew)
+}
+
+// parseFlowBandwidth returns the Mbits/sec of the output of a single flow
+func parseFlowBandwidth(data *types.WorkerOutput) string {
+	switch data.Type {
+	case iperfTcpTest:
+		bw := parseIperfTcpBandwidth(data.Output)
+		if streams := parseIperfTcpStreamBandwidths(data.Output); bw == defaultBandwithFailed && len(streams) == 1 {
+			// iperf3 only prints a SUM line for more than one parallel stream
+			bw = streams[0]
+		}
+		return bw
+	case iperfUdpTest:
+		bw, _, _ := parseIperfUdpResult(data.Output)
+		return bw
+	case nativeTcpTest, nativeUdpTest:
+		if r := data.Throughput; r != nil && r.Bytes > 0 {
+			return fmt.Sprintf("%.2f", r.Mbps)
+		}
+	case netperfTest:
+		return parseNetperfBandwidth(data.Output)
+	}
+	return defaultBandwithFailed
+}
+
+// jainFairness returns Jain's fairness index of the bandwidths, 1 if all flows got the same share
+// down to 1/n if a single flow got everything
+func jainFairness(bandwidths []float64) float64 {
+	var sum, squares float64
+	for _, bw := range bandwidths {
+		sum += bw
+		squares += bw * bw
+	}
+	if squares == 0 {
+		return 0
+	}
+	return sum * sum / (float64(len(bandwidths)) * squares)
+}
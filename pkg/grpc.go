@@ -0,0 +1,271 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Embedded gRPC echo service speaking the gRPC wire protocol over cleartext HTTP/2.
+// Messages are protobuf encoded with a single bytes field (field 1), so any gRPC client
+// with a matching "message Payload { bytes body = 1; }" definition can talk to it:
+//
+//	service Echo {
+//	  rpc Unary(Payload) returns (Payload);
+//	  rpc Stream(stream Payload) returns (stream Payload);
+//	}
+const (
+	grpcUnaryMethod  = "/nptest.Echo/Unary"
+	grpcStreamMethod = "/nptest.Echo/Stream"
+	grpcContentType  = "application/grpc"
+
+	grpcStatusOk            = "0"
+	grpcStatusUnimplemented = "12"
+	grpcStatusInternal      = "13"
+)
+
+// Invoke and indefinitely run the gRPC echo server
+func grpcServer(port string) {
+	integration.PrettyPrintInfo("Starting grpc server on %s", clientData.Worker)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), grpcContentType) {
+			http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", grpcContentType)
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+		if r.URL.Path != grpcUnaryMethod && r.URL.Path != grpcStreamMethod {
+			w.Header().Set("Grpc-Status", grpcStatusUnimplemented)
+			w.Header().Set("Grpc-Message", "unknown method "+r.URL.Path)
+			return
+		}
+
+		flusher, _ := w.(http.Flusher)
+		for {
+			message, err := readGrpcMessage(r.Body)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				w.Header().Set("Grpc-Status", grpcStatusInternal)
+				w.Header().Set("Grpc-Message", err.Error())
+				return
+			}
+			if _, err = w.Write(message); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			if r.URL.Path == grpcUnaryMethod {
+				break
+			}
+		}
+		w.Header().Set("Grpc-Status", grpcStatusOk)
+	})
+
+	server := &http.Server{Addr: ":" + port, Handler: mux, Protocols: new(http.Protocols)}
+	server.Protocols.SetUnencryptedHTTP2(true)
+	if err := server.ListenAndServe(); err != nil {
+		integration.PrettyPrintErr("Grpc server failed on port %s: %s", port, err)
+	}
+}
+
+func newGrpcClient() (*http.Client, *http.Transport) {
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: transport}, transport
+}
+
+// grpcUnaryClient measures unary calls against the echo server, in closed loop or at a fixed QPS
+func grpcUnaryClient(serverHost, serverPort string, params types.Params) *types.LatencyResult {
+	streams := parallelStreams
+	if params.Streams > 0 {
+		streams = params.Streams
+	}
+	duration := time.Duration(defaultDuration) * time.Second
+	if params.Duration > 0 {
+		duration = time.Duration(params.Duration) * time.Second
+	}
+	size := latencyMessage
+	if params.BufferSize > 0 {
+		size = params.BufferSize
+	}
+	url := "http://" + net.JoinHostPort(serverHost, serverPort) + grpcUnaryMethod
+	message := encodeGrpcMessage(make([]byte, size))
+
+	client, transport := newGrpcClient()
+	client.Timeout = latencyTimeout * time.Second
+	defer transport.CloseIdleConnections()
+
+	return driveRequests("grpc", serverHost, streams, duration, params.QPS, func() (int64, error) {
+		request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(message))
+		if err != nil {
+			return 0, err
+		}
+		request.Header.Set("Content-Type", grpcContentType)
+		request.Header.Set("TE", "trailers")
+
+		response, err := client.Do(request)
+		if err != nil {
+			return 0, err
+		}
+		defer response.Body.Close()
+
+		reply, err := readGrpcMessage(response.Body)
+		if err != nil {
+			return 0, err
+		}
+		io.Copy(ioutil.Discard, response.Body)
+		return int64(len(reply)), grpcStatus(response)
+	})
+}
+
+// grpcStreamClient measures the echo throughput of bidirectional streaming calls, one per stream
+func grpcStreamClient(serverHost, serverPort string, params types.Params) *types.ThroughputResult {
+	streams := parallelStreams
+	if params.Streams > 0 {
+		streams = params.Streams
+	}
+	duration := time.Duration(defaultDuration) * time.Second
+	if params.Duration > 0 {
+		duration = time.Duration(params.Duration) * time.Second
+	}
+	size := grpcStreamMessage
+	if params.BufferSize > 0 {
+		size = params.BufferSize
+	}
+	url := "http://" + net.JoinHostPort(serverHost, serverPort) + grpcStreamMethod
+	message := encodeGrpcMessage(make([]byte, size))
+
+	client, transport := newGrpcClient()
+	defer transport.CloseIdleConnections()
+	integration.PrettyPrintInfo("Starting grpc streaming client on %s to %s with %d streams", clientData.Worker, serverHost, streams)
+
+	result := &types.ThroughputResult{Protocol: "grpc", Streams: make([]types.StreamResult, streams)}
+	start := time.Now()
+	deadline := start.Add(duration)
+
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result.Streams[i] = grpcStream(client, url, message, deadline)
+		}(i)
+	}
+	wg.Wait()
+
+	result.Duration = time.Since(start).Seconds()
+	for _, s := range result.Streams {
+		result.Bytes += s.Bytes
+	}
+	result.Mbps = mbps(result.Bytes, result.Duration)
+	return result
+}
+
+func grpcStream(client *http.Client, url string, message []byte, deadline time.Time) (rv types.StreamResult) {
+	start := time.Now()
+	body, writer := io.Pipe()
+	go func() {
+		for time.Now().Before(deadline) {
+			if _, err := writer.Write(message); err != nil {
+				return
+			}
+		}
+		writer.Close()
+	}()
+
+	request, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		rv.Error = err.Error()
+		return
+	}
+	request.Header.Set("Content-Type", grpcContentType)
+	request.Header.Set("TE", "trailers")
+
+	response, err := client.Do(request)
+	if err != nil {
+		body.CloseWithError(err)
+		rv.Error = err.Error()
+		return
+	}
+	defer response.Body.Close()
+
+	for {
+		reply, err := readGrpcMessage(response.Body)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			body.CloseWithError(err)
+			rv.Error = err.Error()
+			return
+		}
+		rv.Bytes += int64(len(reply))
+	}
+	if err := grpcStatus(response); err != nil {
+		rv.Error = err.Error()
+	}
+	rv.Mbps = mbps(rv.Bytes, time.Since(start).Seconds())
+	return
+}
+
+// grpcStatus returns an error unless the trailers of a fully read response carry status OK
+func grpcStatus(response *http.Response) error {
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	if status := response.Trailer.Get("Grpc-Status"); status != grpcStatusOk {
+		return fmt.Errorf("grpc status %s: %s", status, response.Trailer.Get("Grpc-Message"))
+	}
+	return nil
+}
+
+// encodeGrpcMessage frames payload as length prefixed gRPC message of a protobuf with a single bytes field
+func encodeGrpcMessage(payload []byte) []byte {
+	var proto []byte
+	proto = append(proto, 0x0a) // field 1, wire type 2 (length delimited)
+	proto = binary.AppendUvarint(proto, uint64(len(payload)))
+	proto = append(proto, payload...)
+
+	frame := make([]byte, 5, 5+len(proto))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(proto)))
+	return append(frame, proto...)
+}
+
+// readGrpcMessage reads a single length prefixed gRPC message and returns it including its prefix
+func readGrpcMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, fmt.Errorf("compressed grpc messages are not supported")
+	}
+	length := binary.BigEndian.Uint32(header[1:5])
+	if length > grpcMaxMessage {
+		return nil, fmt.Errorf("grpc message of %d bytes exceeds limit", length)
+	}
+	message := make([]byte, 5+length)
+	copy(message, header)
+	if _, err := io.ReadFull(r, message[5:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return message, nil
+}
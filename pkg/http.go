@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Invoke and indefinitely run the embedded HTTP server, speaking HTTP/1.1 and cleartext HTTP/2.
+// Every request is answered with a body of the size given by the size query parameter.
+func httpServer(port string) {
+	integration.PrettyPrintInfo("Starting http server on %s", clientData.Worker)
+	payload := make([]byte, httpMaxResponse)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		size := httpResponseSize
+		if v := r.URL.Query().Get("size"); len(v) > 0 {
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= httpMaxResponse {
+				size = n
+			}
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		w.Write(payload[:size])
+	})
+
+	server := &http.Server{Addr: ":" + port, Handler: mux, Protocols: new(http.Protocols)}
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetUnencryptedHTTP2(true)
+	if err := server.ListenAndServe(); err != nil {
+		integration.PrettyPrintErr("Http server failed on port %s: %s", port, err)
+	}
+}
+
+// httpClient drives the embedded HTTP server on serverHost either in closed loop with one request
+// in flight per stream, or at a fixed rate of requests per second
+func httpClient(serverHost, serverPort string, params types.Params) *types.LatencyResult {
+	streams := parallelStreams
+	if params.Streams > 0 {
+		streams = params.Streams
+	}
+	duration := time.Duration(defaultDuration) * time.Second
+	if params.Duration > 0 {
+		duration = time.Duration(params.Duration) * time.Second
+	}
+	size := httpResponseSize
+	if params.ResponseSize > 0 {
+		size = params.ResponseSize
+	}
+	url := fmt.Sprintf("http://%s/?size=%d", net.JoinHostPort(serverHost, serverPort), size)
+
+	transport := &http.Transport{
+		DisableKeepAlives:   params.KeepAlive == types.KeepAliveOff,
+		MaxIdleConnsPerHost: streams,
+		Protocols:           new(http.Protocols),
+	}
+	protocol := "http/1.1"
+	if params.HttpVersion == types.Http2 {
+		protocol = "h2c"
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	} else {
+		transport.Protocols.SetHTTP1(true)
+	}
+	client := &http.Client{Transport: transport, Timeout: latencyTimeout * time.Second}
+	defer transport.CloseIdleConnections()
+
+	return driveRequests(protocol, serverHost, streams, duration, params.QPS, func() (int64, error) {
+		return httpRequest(client, url)
+	})
+}
+
+// driveRequests runs request either in closed loop with one request in flight per stream, or at
+// a fixed rate of requests per second, and records the latencies of all successful requests
+func driveRequests(protocol, serverHost string, streams int, duration time.Duration, qps int, request func() (int64, error)) *types.LatencyResult {
+	result := &types.LatencyResult{Protocol: protocol, Histogram: types.NewHistogram(latencyHighest, latencyDigits)}
+	var lock sync.Mutex
+	record := func() {
+		sent := time.Now()
+		bytes, err := request()
+		elapsed := int64(time.Since(sent) / time.Microsecond)
+
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			if debug {
+				integration.PrettyPrintDebug("%s request to %s failed: %s", protocol, serverHost, err)
+			}
+			result.Errors++
+			return
+		}
+		result.Histogram.RecordValue(elapsed)
+		result.Requests++
+		result.Bytes += bytes
+	}
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(duration)
+	if qps > 0 {
+		integration.PrettyPrintInfo("Starting %s client on %s to %s at %d requests/sec", protocol, clientData.Worker, serverHost, qps)
+		inFlight := make(chan struct{}, httpMaxInFlight)
+		interval := time.Second / time.Duration(qps)
+		for next := start; next.Before(deadline); next = next.Add(interval) {
+			time.Sleep(time.Until(next))
+			select {
+			case inFlight <- struct{}{}:
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer func() { <-inFlight }()
+					record()
+				}()
+			default:
+				// The server does not keep up with the offered rate
+				lock.Lock()
+				result.Errors++
+				lock.Unlock()
+			}
+		}
+	} else {
+		integration.PrettyPrintInfo("Starting %s client on %s to %s with %d streams", protocol, clientData.Worker, serverHost, streams)
+		for i := 0; i < streams; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for time.Now().Before(deadline) {
+					record()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	result.Duration = time.Since(start).Seconds()
+	return result
+}
+
+func httpRequest(client *http.Client, url string) (int64, error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	bytes, err := io.Copy(ioutil.Discard, response.Body)
+	if err != nil {
+		return bytes, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return bytes, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return bytes, nil
+}
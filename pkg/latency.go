@@ -0,0 +1,179 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Native request/response latency tester: the server echoes every TCP byte stream and every
+// UDP datagram back to its sender, the client keeps exactly one request in flight per stream
+// and records the round trip times in microseconds into a histogram.
+
+// Invoke and indefinitely run the latency echo server
+func latencyServer(port string) {
+	integration.PrettyPrintInfo("Starting latency server on %s", clientData.Worker)
+
+	packetConn, err := net.ListenPacket("udp", ":"+port)
+	if err != nil {
+		integration.PrettyPrintErr("Latency server failed to listen on udp port %s: %s", port, err)
+		return
+	}
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, addr, err := packetConn.ReadFrom(buf)
+			if err != nil {
+				integration.PrettyPrintErr("Latency server failed to read datagram: %s", err)
+				return
+			}
+			packetConn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		integration.PrettyPrintErr("Latency server failed to listen on tcp port %s: %s", port, err)
+		return
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			integration.PrettyPrintErr("Latency server failed to accept connection: %s", err)
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}(conn)
+	}
+}
+
+// latencyClient runs ping-pong round trips against the echo server on serverHost for the duration of the job
+func latencyClient(serverHost, serverPort string, params types.Params, workItemType int) *types.LatencyResult {
+	streams := 1
+	if params.Streams > 0 {
+		streams = params.Streams
+	}
+	duration := time.Duration(defaultDuration) * time.Second
+	if params.Duration > 0 {
+		duration = time.Duration(params.Duration) * time.Second
+	}
+	size := latencyMessage
+	if params.BufferSize > 0 {
+		size = params.BufferSize
+	}
+	address := net.JoinHostPort(serverHost, serverPort)
+
+	result := &types.LatencyResult{Protocol: "tcp", Histogram: types.NewHistogram(latencyHighest, latencyDigits)}
+	roundTrip := latencyTcpStream
+	if workItemType == latencyUdpTest {
+		result.Protocol = "udp"
+		roundTrip = latencyUdpStream
+	}
+	integration.PrettyPrintInfo("Starting %s latency client on %s to %s with %d streams", result.Protocol, clientData.Worker, serverHost, streams)
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(duration)
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			histogram := types.NewHistogram(latencyHighest, latencyDigits)
+			errors := roundTrip(address, size, deadline, histogram)
+
+			lock.Lock()
+			defer lock.Unlock()
+			result.Histogram.Merge(histogram)
+			result.Requests += histogram.TotalCount
+			result.Errors += errors
+		}()
+	}
+	wg.Wait()
+	result.Duration = time.Since(start).Seconds()
+	return result
+}
+
+func latencyTcpStream(address string, size int, deadline time.Time, histogram *types.Histogram) (errors int64) {
+	conn, err := net.DialTimeout("tcp", address, nativeDialTimeout)
+	if err != nil {
+		integration.PrettyPrintErr("Latency client failed to connect to %s: %s", address, err)
+		return 1
+	}
+	defer conn.Close()
+
+	request, response := make([]byte, size), make([]byte, size)
+	for time.Now().Before(deadline) {
+		sent := time.Now()
+		conn.SetDeadline(sent.Add(latencyTimeout * time.Second))
+		if _, err := conn.Write(request); err != nil {
+			return errors + 1
+		}
+		if _, err := io.ReadFull(conn, response); err != nil {
+			// The stream is out of sync after a partial read, so a failure ends it
+			return errors + 1
+		}
+		histogram.RecordValue(int64(time.Since(sent) / time.Microsecond))
+	}
+	return
+}
+
+func latencyUdpStream(address string, size int, deadline time.Time, histogram *types.Histogram) (errors int64) {
+	conn, err := net.DialTimeout("udp", address, nativeDialTimeout)
+	if err != nil {
+		integration.PrettyPrintErr("Latency client failed to connect to %s: %s", address, err)
+		return 1
+	}
+	defer conn.Close()
+
+	if size < 8 {
+		size = 8
+	}
+	request, response := make([]byte, size), make([]byte, size)
+	for seq := uint64(0); time.Now().Before(deadline); seq++ {
+		binary.BigEndian.PutUint64(request, seq)
+		sent := time.Now()
+		conn.SetDeadline(sent.Add(latencyTimeout * time.Second))
+		if _, err := conn.Write(request); err != nil {
+			errors++
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		// Replies to earlier requests which timed out are skipped
+		for {
+			n, err := conn.Read(response)
+			if err != nil {
+				errors++
+				break
+			}
+			if n >= 8 && binary.BigEndian.Uint64(response) == seq {
+				histogram.RecordValue(int64(time.Since(sent) / time.Microsecond))
+				break
+			}
+		}
+	}
+	return
+}
+
+// formatLatencyResult renders a latency result for the raw output file
+func formatLatencyResult(result *types.LatencyResult) string {
+	h := result.Histogram
+	return fmt.Sprintf("latency %s: %.2f sec, %d requests, %.1f requests/sec, %d errors, %d bytes, p50 %d us, p99 %d us, p99.9 %d us, max %d us, mean %.1f us\n",
+		result.Protocol, result.Duration, result.Requests, requestsPerSecond(result), result.Errors, result.Bytes,
+		h.ValueAtPercentile(50), h.ValueAtPercentile(99), h.ValueAtPercentile(99.9), h.Max, h.Mean())
+}
+
+func requestsPerSecond(result *types.LatencyResult) float64 {
+	if result.Duration <= 0 {
+		return 0
+	}
+	return float64(result.Requests) / result.Duration
+}
@@ -10,12 +10,15 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Regexes to parse the Mbits/sec out of iperf TCP, UDP and netperf output
 var iperfTCPOutputRegexp = regexp.MustCompile("SUM.*\\s+(\\d+)\\sMbits/sec\\s+receiver")
-var iperfUDPOutputRegexp = regexp.MustCompile("\\s+(\\S+)\\sMbits/sec\\s+\\S+\\s+ms\\s+")
+var iperfTCPStreamOutputRegexp = regexp.MustCompile("\\[\\s*\\d+\\](?:\\[\\S+\\])?\\s+\\S+\\s+sec\\s+\\S+\\s+\\S+\\s+(\\S+)\\sMbits/sec.*receiver")
+var iperfUDPOutputRegexp = regexp.MustCompile("\\s+(\\S+)\\sMbits/sec\\s+(\\S+)\\s+ms\\s+\\d+/\\d+\\s+\\((\\S+)%\\)")
 var netperfOutputRegexp = regexp.MustCompile("\\s+\\d+\\s+\\d+\\s+\\d+\\s+\\S+\\s+(\\S+)\\s*")
 
 var dataPoints = make(map[string][]types.Point)
@@ -23,44 +26,167 @@ var dataPointKeys []string
 var datapointsFlushed bool
 
 var testcases []*types.Testcase
-var currentJobIndex = 0
+var jobs []*types.Job
 
 var globalLock sync.Mutex
+var workChanged = sync.NewCond(&globalLock) // Signalled whenever the allocation for waiting workers may have changed
 var workerStateMap = make(map[string]*types.WorkerState)
 
 const csvSeparator = ";"
 const defaultBandwithFailed = "-1"
+
 type NetPerfRpc int
 
 // Blocking RPC server start - only runs on the orchestrator
 func Orchestrate(d bool) {
 	debug = d
 	testcases = []*types.Testcase{
-		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "1 iperf TCP. Same VM using Pod IP", Type: iperfTcpTest, ClusterIP: false, MSS: mssMin},
-		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "2 iperf TCP. Same VM using Virtual IP", Type: iperfTcpTest, ClusterIP: true, MSS: mssMin},
-		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "3 iperf TCP. Remote VM using Pod IP", Type: iperfTcpTest, ClusterIP: false, MSS: mssMin},
-		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "4 iperf TCP. Remote VM using Virtual IP", Type: iperfTcpTest, ClusterIP: true, MSS: mssMin},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "1 iperf TCP. Same VM using Pod IP", Type: iperfTcpTest, ClusterIP: false, Sweep: mssSweep},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "2 iperf TCP. Same VM using Virtual IP", Type: iperfTcpTest, ClusterIP: true, Sweep: mssSweep},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "3 iperf TCP. Remote VM using Pod IP", Type: iperfTcpTest, ClusterIP: false, Sweep: mssSweep},
+		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "4 iperf TCP. Remote VM using Virtual IP", Type: iperfTcpTest, ClusterIP: true, Sweep: mssSweep},
 
-		{SourceNode: "netperf-w2", DestinationNode: "netperf-w2", Label: "5 iperf TCP. Hairpin Pod to own Virtual IP", Type: iperfTcpTest, ClusterIP: true, MSS: mssMin},
+		{SourceNode: "netperf-w2", DestinationNode: "netperf-w2", Label: "5 iperf TCP. Hairpin Pod to own Virtual IP", Type: iperfTcpTest, ClusterIP: true, Sweep: mssSweep},
 
-		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "6 iperf UDP. Same VM using Pod IP", Type: iperfUdpTest, ClusterIP: false, MSS: mssMax},
-		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "7 iperf UDP. Same VM using Virtual IP", Type: iperfUdpTest, ClusterIP: true, MSS: mssMax},
-		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "8 iperf UDP. Remote VM using Pod IP", Type: iperfUdpTest, ClusterIP: false, MSS: mssMax},
-		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "9 iperf UDP. Remote VM using Virtual IP", Type: iperfUdpTest, ClusterIP: true, MSS: mssMax},
+		// The unlimited UDP testcases run once, the MSS does not apply to UDP and was never passed to iperf3 for them
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "6 iperf UDP. Same VM using Pod IP", Type: iperfUdpTest, ClusterIP: false},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "7 iperf UDP. Same VM using Virtual IP", Type: iperfUdpTest, ClusterIP: true},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "8 iperf UDP. Remote VM using Pod IP", Type: iperfUdpTest, ClusterIP: false},
+		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "9 iperf UDP. Remote VM using Virtual IP", Type: iperfUdpTest, ClusterIP: true},
 
 		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "10 netperf. Same VM using Pod IP", Type: netperfTest, ClusterIP: false},
 		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "11 netperf. Same VM using Virtual IP", Type: netperfTest, ClusterIP: true},
 		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "12 netperf. Remote VM using Pod IP", Type: netperfTest, ClusterIP: false},
 		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "13 netperf. Remote VM using Virtual IP", Type: netperfTest, ClusterIP: true},
+
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "14 iperf UDP rate sweep. Same VM using Pod IP", Type: iperfUdpTest, ClusterIP: false, Sweep: rateSweep, LossThreshold: udpLossThreshold},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "15 iperf UDP rate sweep. Same VM using Virtual IP", Type: iperfUdpTest, ClusterIP: true, Sweep: rateSweep, LossThreshold: udpLossThreshold},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "16 iperf UDP rate sweep. Remote VM using Pod IP", Type: iperfUdpTest, ClusterIP: false, Sweep: rateSweep, LossThreshold: udpLossThreshold},
+		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "17 iperf UDP rate sweep. Remote VM using Virtual IP", Type: iperfUdpTest, ClusterIP: true, Sweep: rateSweep, LossThreshold: udpLossThreshold},
+
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "18 iperf TCP stream scaling. Same VM using Pod IP", Type: iperfTcpTest, ClusterIP: false, Params: types.Params{MSS: mssMax}, Sweep: streamSweep},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "19 iperf TCP stream scaling. Same VM using Virtual IP", Type: iperfTcpTest, ClusterIP: true, Params: types.Params{MSS: mssMax}, Sweep: streamSweep},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "20 iperf TCP stream scaling. Remote VM using Pod IP", Type: iperfTcpTest, ClusterIP: false, Params: types.Params{MSS: mssMax}, Sweep: streamSweep},
+		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "21 iperf TCP stream scaling. Remote VM using Virtual IP", Type: iperfTcpTest, ClusterIP: true, Params: types.Params{MSS: mssMax}, Sweep: streamSweep},
+
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "22 iperf TCP bidirectional. Same VM using Pod IP", Type: iperfTcpTest, ClusterIP: false, Params: types.Params{MSS: mssMax, Direction: types.DirectionBidir}},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "23 iperf TCP bidirectional. Same VM using Virtual IP", Type: iperfTcpTest, ClusterIP: true, Params: types.Params{MSS: mssMax, Direction: types.DirectionBidir}},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "24 iperf TCP bidirectional. Remote VM using Pod IP", Type: iperfTcpTest, ClusterIP: false, Params: types.Params{MSS: mssMax, Direction: types.DirectionBidir}},
+		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "25 iperf TCP bidirectional. Remote VM using Virtual IP", Type: iperfTcpTest, ClusterIP: true, Params: types.Params{MSS: mssMax, Direction: types.DirectionBidir}},
+
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "26 native TCP. Same VM using Pod IP", Type: nativeTcpTest, ClusterIP: false, Params: types.Params{MSS: mssMax}},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "27 native TCP. Same VM using Virtual IP", Type: nativeTcpTest, ClusterIP: true, Params: types.Params{MSS: mssMax}},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "28 native TCP. Remote VM using Pod IP", Type: nativeTcpTest, ClusterIP: false, Params: types.Params{MSS: mssMax}},
+		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "29 native TCP. Remote VM using Virtual IP", Type: nativeTcpTest, ClusterIP: true, Params: types.Params{MSS: mssMax}},
+
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "30 native UDP rate sweep. Same VM using Pod IP", Type: nativeUdpTest, ClusterIP: false, Sweep: rateSweep, LossThreshold: udpLossThreshold},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "31 native UDP rate sweep. Remote VM using Pod IP", Type: nativeUdpTest, ClusterIP: false, Sweep: rateSweep, LossThreshold: udpLossThreshold},
+
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "32 TCP latency. Same VM using Pod IP", Type: latencyTcpTest, ClusterIP: false, Repetitions: latencyRepetitions},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "33 TCP latency. Same VM using Virtual IP", Type: latencyTcpTest, ClusterIP: true, Repetitions: latencyRepetitions},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "34 TCP latency. Remote VM using Pod IP", Type: latencyTcpTest, ClusterIP: false, Repetitions: latencyRepetitions},
+		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "35 TCP latency. Remote VM using Virtual IP", Type: latencyTcpTest, ClusterIP: true, Repetitions: latencyRepetitions},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "36 UDP latency. Same VM using Pod IP", Type: latencyUdpTest, ClusterIP: false, Repetitions: latencyRepetitions},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "37 UDP latency. Remote VM using Pod IP", Type: latencyUdpTest, ClusterIP: false, Repetitions: latencyRepetitions},
+
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "38 HTTP closed loop. Same VM using Pod IP", Type: httpTest, ClusterIP: false, Sweep: httpSweep},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "39 HTTP closed loop. Same VM using Virtual IP", Type: httpTest, ClusterIP: true, Sweep: httpSweep},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "40 HTTP closed loop. Remote VM using Pod IP", Type: httpTest, ClusterIP: false, Sweep: httpSweep},
+		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "41 HTTP closed loop. Remote VM using Virtual IP", Type: httpTest, ClusterIP: true, Sweep: httpSweep},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "42 HTTP fixed QPS. Remote VM using Pod IP", Type: httpTest, ClusterIP: false, Params: types.Params{QPS: httpFixedQPS}, Sweep: httpSweep},
+		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "43 HTTP fixed QPS. Remote VM using Virtual IP", Type: httpTest, ClusterIP: true, Params: types.Params{QPS: httpFixedQPS}, Sweep: httpSweep},
+
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "44 gRPC unary. Same VM using Pod IP", Type: grpcUnaryTest, ClusterIP: false},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "45 gRPC unary. Same VM using Virtual IP", Type: grpcUnaryTest, ClusterIP: true},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "46 gRPC unary. Remote VM using Pod IP", Type: grpcUnaryTest, ClusterIP: false},
+		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "47 gRPC unary. Remote VM using Virtual IP", Type: grpcUnaryTest, ClusterIP: true},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "48 gRPC streaming. Same VM using Pod IP", Type: grpcStreamTest, ClusterIP: false},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "49 gRPC streaming. Same VM using Virtual IP", Type: grpcStreamTest, ClusterIP: true},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "50 gRPC streaming. Remote VM using Pod IP", Type: grpcStreamTest, ClusterIP: false},
+		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "51 gRPC streaming. Remote VM using Virtual IP", Type: grpcStreamTest, ClusterIP: true},
+
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "52 TCP connection rate. Same VM using Pod IP", Type: connRateTest, ClusterIP: false},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "53 TCP connection rate. Same VM using Virtual IP", Type: connRateTest, ClusterIP: true},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "54 TCP connection rate. Remote VM using Pod IP", Type: connRateTest, ClusterIP: false},
+		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "55 TCP connection rate. Remote VM using Virtual IP", Type: connRateTest, ClusterIP: true},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "56 TCP connection scale. Remote VM using Pod IP", Type: connScaleTest, ClusterIP: false, Sweep: connSweep},
+		{SourceNode: "netperf-w3", DestinationNode: "netperf-w2", Label: "57 TCP connection scale. Remote VM using Virtual IP", Type: connScaleTest, ClusterIP: true, Sweep: connSweep},
+
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "58 DNS lookup of a Service name", Type: dnsTest, ClusterIP: true, Repetitions: latencyRepetitions},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "59 DNS lookup of a fully qualified name", Type: dnsTest, ClusterIP: true, Repetitions: latencyRepetitions, Params: types.Params{DnsNames: dnsFqdn}},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "60 DNS lookup of a non-existent name", Type: dnsTest, ClusterIP: true, Repetitions: latencyRepetitions, Params: types.Params{DnsNames: dnsNonExistent}},
+
+		{Label: "61 iperf TCP incast. Worker 1 and 3 to Worker 2 using Pod IP", Type: iperfTcpTest, Flows: incastFlows, Start: types.StartBarrier},
+		{Label: "62 iperf TCP incast. Worker 1 and 3 to Worker 2 using Virtual IP", Type: iperfTcpTest, ClusterIP: true, Flows: incastFlows, Start: types.StartBarrier},
+		{Label: "63 iperf TCP fan-out. Worker 1 to Worker 2 and 3 using Pod IP", Type: iperfTcpTest, Flows: fanOutFlows},
+		{Label: "64 iperf TCP fan-out. Worker 1 to Worker 2 and 3 using Virtual IP", Type: iperfTcpTest, ClusterIP: true, Flows: fanOutFlows},
+	}
+
+	// Both need more than the three worker pods, so they are only scheduled on demand. They sweep the MSS like
+	// the Pod and Virtual IP testcases, so the overhead against the host baseline compares equal segment sizes
+	if envEnabled(EnvNodePortTests) {
+		testcases = append(testcases,
+			&types.Testcase{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "65 iperf TCP. Same VM using NodePort", Type: iperfTcpTest, NodePort: true, Sweep: mssSweep},
+			&types.Testcase{SourceNode: "netperf-w1", DestinationNode: "netperf-w3", Label: "66 iperf TCP. Remote VM using NodePort", Type: iperfTcpTest, NodePort: true, Sweep: mssSweep},
+		)
+	}
+	if envEnabled(EnvHostNetworkTests) {
+		testcases = append(testcases,
+			&types.Testcase{SourceNode: "netperf-h1", DestinationNode: "netperf-h2", Label: "67 iperf TCP. Remote VM host to host", Type: iperfTcpTest, Sweep: mssSweep},
+			&types.Testcase{SourceNode: "netperf-h1", DestinationNode: "netperf-w3", Label: "68 iperf TCP. Remote VM host network to Pod IP", Type: iperfTcpTest, Sweep: mssSweep},
+		)
+	}
+
+	testcases = append(testcases, egressTestcases(os.Getenv(EnvEgressTarget), os.Getenv(EnvEgressNetperfTarget))...)
+
+	// Worker Services of existing deployments only expose the iperf3 and netperf ports
+	if !envEnabled(EnvServicePortTests) {
+		var kept []*types.Testcase
+		for _, v := range testcases {
+			if !needsServicePorts(v) {
+				kept = append(kept, v)
+			}
+		}
+		testcases = kept
+	}
+
+	var err error
+	if testcases, err = expandFamilies(testcases, os.Getenv(EnvAddressFamilies)); err != nil {
+		integration.PrettyPrintErr("Invalid %s: %s", EnvAddressFamilies, err)
+		os.Exit(1)
+	}
+	if jobs, err = expandJobs(testcases); err != nil {
+		integration.PrettyPrintErr("Invalid testcase schedule: %s", err)
+		os.Exit(1)
+	}
+	integration.PrettyPrintInfo("Scheduled %d jobs for %d testcases", len(jobs), len(testcases))
+	if currentRun, err = newRun(os.Getenv(EnvRunTags)); err != nil {
+		integration.PrettyPrintErr("Invalid %s: %s", EnvRunTags, err)
+		os.Exit(1)
+	}
+
+	checkpoint := checkpointFile
+	if value, ok := os.LookupEnv(EnvCheckpointFile); ok {
+		checkpoint = value
 	}
+	resumeCheckpoint(checkpoint)
 
-	initializeOutputFiles(outputCaptureFile)
-	initializeOutputFiles(resultCaptureFile)
+	base := resultBaseDir
+	if value := os.Getenv(EnvResultDir); len(value) > 0 {
+		base = value
+	}
+	initializeRunDirectory(base)
 	serveRPCRequests(rpcServicePort)
 }
 
+// envEnabled reports whether the boolean environment variable is set to true
+func envEnabled(name string) bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(name))
+	return enabled
+}
+
 func initializeOutputFiles(file string) {
-	fd, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0666)
+	fd, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		integration.PrettyPrintErr("Failed to open output capture file: %s", err)
 		os.Exit(2)
@@ -72,6 +198,7 @@ func serveRPCRequests(port string) {
 	baseObject := new(NetPerfRpc)
 	rpc.Register(baseObject)
 	rpc.HandleHTTP()
+	http.HandleFunc(archivePath, serveArchive)
 	listener, e := net.Listen("tcp", ":"+port)
 	if e != nil {
 		integration.PrettyPrintErr("rpc listen error: %s", e)
@@ -80,193 +207,391 @@ func serveRPCRequests(port string) {
 	http.Serve(listener, nil)
 }
 
-// RegisterClient registers a single and assign a work item to it
+// RegisterClient registers a single worker and asks it to start its servers
 func (t *NetPerfRpc) RegisterClient(data *types.Worker, reply *types.WorkItem) error {
 	globalLock.Lock()
 	defer globalLock.Unlock()
 
-	state, ok := workerStateMap[data.Worker]
+	registered := &types.WorkerState{SentServerItem: true, Idle: true, IP: data.IP, IPs: data.IPs, Worker: data.Worker, ServiceName: data.ServiceName,
+		ServiceIPs: data.ServiceIPs, HostNetwork: data.HostNetwork, NodeIPs: data.NodeIPs, NodePorts: data.NodePorts, Fingerprint: data.Fingerprint}
+	// Missing addresses abort the pre-flight checks together with every other problem, a worker joining
+	// later is turned away instead
+	problems := checkWorkerAddresses(registered)
+	if preflightPassed && len(problems) > 0 {
+		return fmt.Errorf("worker %s %s", data.Worker, strings.Join(problems, "; "))
+	}
+	registrationProblems[data.Worker] = problems
+	checkFingerprints(registered)
 
-	if !ok {
-		// For new clients, trigger an iperf server start immediately
-		state = &types.WorkerState{SentServerItem: true, Idle: true, IP: data.IP, Worker: data.Worker}
-		integration.PrettyPrintOk("Registering new client: %+v", state)
-		workerStateMap[data.Worker] = state
-		reply.IsServerItem = true
-		reply.ServerItem.ListenPort = iperf3ServerPort
-		reply.ServerItem.Timeout = 3600
-		return nil
+	if state, ok := workerStateMap[data.Worker]; !ok {
+		integration.PrettyPrintOk("Registering new client: %+v", registered)
+		workerStateMap[data.Worker] = registered
 	} else {
-		if debug {
-			integration.PrettyPrintSkipped("Client %s already registered", state.Worker)
+		// A restarted worker process registers again, possibly with other addresses as a new pod.
+		// The servers and the work items of the previous one are gone.
+		integration.PrettyPrintInfo("Client %s registered again: %+v", data.Worker, registered)
+		*state = *registered
+		if !preflightPassed {
+			// Checks handed out to the previous process are lost
+			delete(preflightReports, data.Worker)
 		}
+		requeueFlows(data.Worker)
 	}
+	workChanged.Broadcast()
 
-	// Worker defaults to idle unless the allocateWork routine below assigns an item
-	state.Idle = true
-
-	// Give the worker a new work item or let it idle loop another 5 seconds
-	allocateWorkToClient(state, reply)
+	reply.IsServerItem = true
+	reply.ServerItem.ListenPort = iperf3ServerPort
+	reply.ServerItem.Timeout = 3600
 	return nil
 }
 
+// requeueFlows hands the flows of a restarted worker out again which it did not report yet. The jobs would
+// otherwise never be done, and their destinations would sample the CPU until the job timed out.
+func requeueFlows(worker string) {
+	for _, job := range jobs {
+		if job.Done {
+			continue
+		}
+		testcase := testcases[job.Testcase]
+		for k, flow := range testcaseFlows(testcase) {
+			if flow.SourceNode != worker || !job.Assigned[k] || isMultiFlow(testcase) && job.Flows[k].Reported {
+				continue
+			}
+			integration.PrettyPrintWarn("Requeueing flow %d of job '%s' %s of the restarted worker %s", k+1, testcase.Label,
+				job.Params.Format(testcase.Sweep), worker)
+			job.Assigned[k] = false
+			job.Finished = false
+		}
+	}
+}
+
+// FetchWork blocks until a work item can be allocated to the worker, or the keepalive of the request passed
+func (t *NetPerfRpc) FetchWork(data *types.WorkRequest, reply *types.WorkItem) error {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+
+	state, ok := workerStateMap[data.Worker]
+	if !ok {
+		return fmt.Errorf("client %s is not registered", data.Worker)
+	}
+
+	keepalive := data.Keepalive
+	if keepalive < 1 {
+		keepalive = 1
+	}
+	expired := false
+	timer := time.AfterFunc(time.Duration(keepalive)*time.Second, func() {
+		globalLock.Lock()
+		defer globalLock.Unlock()
+		expired = true
+		workChanged.Broadcast()
+	})
+	defer timer.Stop()
+
+	// The worker waits idle, which may be what the allocation for others waits for
+	state.Idle = true
+	workChanged.Broadcast()
+	for {
+		*reply = types.WorkItem{}
+		allocateWorkToClient(state, reply)
+		if !reply.IsIdle {
+			// Handing out work starts a job, its destinations are waiting to sample their CPU
+			workChanged.Broadcast()
+			return nil
+		}
+		if expired {
+			return nil
+		}
+		workChanged.Wait()
+	}
+}
+
 // ReceiveOutput processes a data received from a single client
 func (t *NetPerfRpc) ReceiveOutput(data *types.WorkerOutput, reply *int) error {
 	globalLock.Lock()
-	defer globalLock.Unlock()
+	if data.Job < 0 || data.Job >= len(jobs) {
+		globalLock.Unlock()
+		integration.PrettyPrintWarn("Dropping output of worker %s for unknown job %d", data.Worker, data.Job)
+		return nil
+	}
+	receiveOutput(data)
+	checkpoint := snapshotCheckpoint()
+	// Wakes the destination workers waiting for the job to be done
+	workChanged.Broadcast()
+	globalLock.Unlock()
 
-	testcase := testcases[currentJobIndex]
+	writeCheckpoint(checkpoint)
+	return nil
+}
+
+// receiveOutput records the output of a job, the caller holds globalLock
+func receiveOutput(data *types.WorkerOutput) {
+	index := data.Job
+	job := jobs[index]
+	testcase := testcases[job.Testcase]
+	params := job.Params.Format(testcase.Sweep)
+	if isMultiFlow(testcase) {
+		receiveFlowOutput(data, index)
+		return
+	}
+	job.Done = true
 
 	var outputLog string
 	var bw string
 
 	switch data.Type {
-	case iperfTcpTest:
-		mss := testcases[currentJobIndex].MSS - mssStepSize
-		outputLog = outputLog + fmt.Sprintln("Received TCP output from worker", data.Worker, "for test", testcase.Label,
-			"from", testcase.SourceNode, "to", testcase.DestinationNode, "MSS:", mss) + data.Output
-		writeOutputFile(outputCaptureFile, outputLog)
-		bw = parseIperfTcpBandwidth(data.Output)
-		registerDataPoint(testcase.Label, mss, bw, currentJobIndex)
+	case iperfTcpTest, iperfUdpTest:
+		protocol := "TCP"
+		if data.Type == iperfUdpTest {
+			protocol = "UDP"
+		}
+		outputLog = outputLog + fmt.Sprintln("Received", protocol, "output from worker", data.Worker, "for test", testcase.Label,
+			"from", testcase.SourceNode, "to", testcase.DestinationNode, params) + data.Output
+		writeOutputFile(runFile(jobOutputName(index)), outputLog)
+		job.IperfCpu = parseIperfCpu(data.Output)
+
+		switch job.Params.Direction {
+		case types.DirectionBidir:
+			// Both directions are reported in one output, tagged with the role of the client
+			tx, rx := splitIperfBidirOutput(data.Output)
+			bw = registerIperfDataPoint(testcase, index, data.Type, types.DirectionUpload, tx) + "/" +
+				registerIperfDataPoint(testcase, index, data.Type, types.DirectionDownload, rx)
+		case types.DirectionDownload:
+			bw = registerIperfDataPoint(testcase, index, data.Type, types.DirectionDownload, data.Output)
+		default:
+			bw = registerIperfDataPoint(testcase, index, data.Type, types.DirectionUpload, data.Output)
+		}
 
-	case iperfUdpTest:
-		mss := testcases[currentJobIndex].MSS - mssStepSize
-		outputLog = outputLog + fmt.Sprintln("Received UDP output from worker", data.Worker, "for test", testcase.Label,
-			"from", testcase.SourceNode, "to", testcase.DestinationNode, "MSS:", mss) + data.Output
-		writeOutputFile(outputCaptureFile, outputLog)
-		bw = parseIperfUdpBandwidth(data.Output)
-		registerDataPoint(testcase.Label, mss, bw, currentJobIndex)
+	case nativeTcpTest, nativeUdpTest, grpcStreamTest:
+		outputLog = outputLog + fmt.Sprintln("Received native output from worker", data.Worker, "for test", testcase.Label,
+			"from", testcase.SourceNode, "to", testcase.DestinationNode, params) + data.Output
+		writeOutputFile(runFile(jobOutputName(index)), outputLog)
+		point := types.Point{Params: job.Params, Direction: types.DirectionUpload, Bandwidth: defaultBandwithFailed, Index: index}
+		if r := data.Throughput; r != nil && r.Bytes > 0 {
+			point.Bandwidth = fmt.Sprintf("%.2f", r.Mbps)
+			for _, s := range r.Streams {
+				point.StreamBandwidths = append(point.StreamBandwidths, fmt.Sprintf("%.2f", s.Mbps))
+			}
+			if data.Type == nativeUdpTest {
+				point.Jitter, point.Loss = fmt.Sprintf("%.3f", r.Jitter), fmt.Sprintf("%g", r.LossPercent)
+			}
+		}
+		registerDataPoint(testcase.Label, point)
+		bw = point.Bandwidth
+
+	case latencyTcpTest, latencyUdpTest, httpTest, grpcUnaryTest, connRateTest, connScaleTest, dnsTest:
+		outputLog = outputLog + fmt.Sprintln("Received latency output from worker", data.Worker, "for test", testcase.Label,
+			"from", testcase.SourceNode, "to", testcase.DestinationNode, params) + data.Output
+		writeOutputFile(runFile(jobOutputName(index)), outputLog)
+		latency := data.Latency
+		if latency == nil || latency.Histogram == nil {
+			latency = &types.LatencyResult{Histogram: types.NewHistogram(latencyHighest, latencyDigits)}
+		}
+		registerDataPoint(testcase.Label, types.Point{Params: job.Params, Direction: types.DirectionUpload, Index: index, Latency: latency, Dns: data.Dns})
+		integration.PrettyPrintInfo("Job done from worker %s p50 latency was %d us", data.Worker, latency.Histogram.ValueAtPercentile(50))
+		return
 
 	case netperfTest:
 		outputLog = outputLog + fmt.Sprintln("Received netperf output from worker", data.Worker, "for test", testcase.Label,
-			"from", testcase.SourceNode, "to", testcase.DestinationNode) + data.Output
-		writeOutputFile(outputCaptureFile, outputLog)
+			"from", testcase.SourceNode, "to", testcase.DestinationNode, params) + data.Output
+		writeOutputFile(runFile(jobOutputName(index)), outputLog)
 		bw = parseNetperfBandwidth(data.Output)
-		registerDataPoint(testcase.Label, 0, bw, currentJobIndex)
-		testcases[currentJobIndex].Finished = true
+		registerDataPoint(testcase.Label, types.Point{Params: job.Params, Direction: types.DirectionUpload, Bandwidth: bw, Index: index})
 
 	}
 	integration.PrettyPrintInfo("Job done from worker %s Bandwidth was %s Mbits/sec", data.Worker, bw)
-	return nil
 }
 
-func allocateWorkToClient(worker *types.WorkerState, reply *types.WorkItem) {
-	if !allWorkersIdle() {
-		reply.IsIdle = true
-		return
+// registerIperfDataPoint parses the iperf output of a single direction and registers it as data point.
+// Only the default direction keeps the plain testcase label, so both directions end up as separate series.
+func registerIperfDataPoint(testcase *types.Testcase, index int, testType int, direction, output string) string {
+	job := jobs[index]
+	point := types.Point{Params: job.Params, Direction: direction, Index: index}
+
+	switch testType {
+	case iperfTcpTest:
+		point.Bandwidth = parseIperfTcpBandwidth(output)
+		point.StreamBandwidths = parseIperfTcpStreamBandwidths(output)
+		if point.Bandwidth == defaultBandwithFailed && len(point.StreamBandwidths) == 1 {
+			// iperf3 only prints a SUM line for more than one parallel stream
+			point.Bandwidth = point.StreamBandwidths[0]
+		}
+
+	case iperfUdpTest:
+		point.Bandwidth, point.Jitter, point.Loss = parseIperfUdpResult(output)
+		integration.PrettyPrintInfo("Jitter was %s ms, loss was %s%%", point.Jitter, point.Loss)
 	}
 
-	if debug {
-		integration.PrettyPrintDebug("System is all idle - pick up next work item to allocate to client")
+	label := testcase.Label
+	if len(job.Params.Direction) > 0 && job.Params.Direction != types.DirectionUpload {
+		label = fmt.Sprintf("%s (%s)", label, direction)
 	}
-	for n, v := range testcases {
-		if debug {
-			integration.PrettyPrintDebug("System is all idle - pick up next work item to allocate to client")
-		}
-		if v.Finished {
+	registerDataPoint(label, point)
+	return point.Bandwidth
+}
+
+func allocateWorkToClient(worker *types.WorkerState, reply *types.WorkItem) {
+	if allocatePreflight(worker, reply) {
+		return
+	}
+	for n, job := range jobs {
+		if job.Finished {
 			continue
 		}
-		if v.SourceNode != worker.Worker {
-			reply.IsIdle = true
-			return
+		v := testcases[job.Testcase]
+		flows := testcaseFlows(v)
+
+		// The flows of a multi-flow job which already started are handed out while the others run
+		if !isStarted(job) {
+			if !allWorkersIdle() {
+				reply.IsIdle = true
+				return
+			}
+			if debug {
+				integration.PrettyPrintDebug("System is all idle - pick up next work item to allocate to client")
+			}
 		}
-		if _, ok := workerStateMap[v.DestinationNode]; !ok {
+		for _, flow := range flows {
+			_, sourceOk := workerStateMap[flow.SourceNode]
+			_, destinationOk := workerStateMap[flow.DestinationNode]
+			if !sourceOk || (!destinationOk && !v.External) {
+				reply.IsIdle = true
+				return
+			}
+		}
+
+		started := isStarted(job)
+		scheduleStart(v, job)
+		var items []types.IperfClientWorkItem
+		for k, flow := range flows {
+			if job.Assigned[k] || flow.SourceNode != worker.Worker {
+				continue
+			}
+			integration.PrettyPrintInfo("Requesting job '%s' from %s to %s %s", v.Label, flow.SourceNode, flow.DestinationNode, job.Params.Format(v.Sweep))
+			item := types.IperfClientWorkItem{Type: v.Type, Params: job.Params, Job: n, Flow: k, Start: v.Start, StartAt: job.StartAt}
+			item.Host, item.Port = flowTarget(v, job, flow.DestinationNode, destinationSlot(flows, k))
+			job.Assigned[k] = true
+			if isMultiFlow(v) {
+				job.Flows[k].Address = item.Host
+			}
+			items = append(items, item)
+		}
+		monitor := needsMonitor(v, job, worker.Worker)
+		if len(items) == 0 && !monitor {
 			reply.IsIdle = true
 			return
 		}
-		integration.PrettyPrintInfo("Requesting job '%s' from %s to %s for MSS %d", v.Label, v.SourceNode, v.DestinationNode, v.MSS)
-		reply.ClientItem.Type = v.Type
-		reply.IsClientItem = true
-		worker.Idle = false
-		currentJobIndex = n
-
-		if v.ClusterIP {
-			reply.ClientItem.Host = getWorkerPodName(v.DestinationNode)
-		} else {
-			reply.ClientItem.Host = getWorkerPodIP(v.DestinationNode)
+		if !started {
+			// A job which was running when the orchestrator restarted runs again from scratch
+			resetJobOutput(n)
 		}
 
-		switch {
-		case v.Type == iperfTcpTest || v.Type == iperfUdpTest:
-			reply.ClientItem.Port = iperf3ServerPort
-			reply.ClientItem.MSS = v.MSS
-
-			v.MSS = v.MSS + mssStepSize
-			if v.MSS > mssMax {
-				v.Finished = true
+		if len(items) > 0 {
+			reply.ClientItem, reply.ClientItems = items[0], items[1:]
+			reply.IsClientItem = true
+			if !isMultiFlow(v) {
+				job.Address = reply.ClientItem.Host
 			}
-			return
-
-		case v.Type == netperfTest:
-			reply.ClientItem.Port = netperfServerPort
-			return
 		}
-	}
-
-	for _, v := range testcases {
-		if !v.Finished {
-			return
+		if monitor {
+			reply.MonitorItem = types.MonitorWorkItem{Job: n, Start: v.Start, StartAt: job.StartAt}
+			reply.IsMonitorItem = true
+			job.Monitors = append(job.Monitors, worker.Worker)
 		}
+		worker.Idle = false
+		job.Finished = isAssigned(job) && isMonitored(v, job)
+		return
 	}
 
+	// The last jobs are done once their workers asked for work again
+	if !allWorkersIdle() {
+		reply.IsIdle = true
+		return
+	}
 	if !datapointsFlushed {
-		integration.PrettyPrint("ALL TESTCASES AND MSS RANGES COMPLETE - " + csvDataMarker)
+		integration.PrettyPrint("ALL TESTCASES AND SWEEPS COMPLETE - " + csvDataMarker)
 		flushDataPointsToCsv()
+		currentRun.Finished = time.Now().UTC()
+		results := collectResults()
+		writeResultsJson(results)
+		storeRun(results)
 		datapointsFlushed = true
+		writeManifest()
+		removeCheckpoint()
 	}
 
 	reply.IsIdle = true
 }
 
-func flushDataPointsToCsv() {
-	var buffer string
-
-	// Write the MSS points for the X-axis before dumping all the testcase datapoints
-	for _, points := range dataPoints {
-		if len(points) == 1 {
-			continue
-		}
-		buffer = fmt.Sprintf("%-45s%s Maximum%s", "MSS", csvSeparator, csvSeparator)
-		for _, p := range points {
-			buffer = buffer + fmt.Sprintf(" %d%s", p.Mss, csvSeparator)
-		}
-		break
+// egressTestcases returns the testcases against external servers. These are provided by the user, so the
+// testcases only run against a configured target, the iperf3 and the netperf server given as host[:port].
+func egressTestcases(target, netperfTarget string) (rv []*types.Testcase) {
+	if len(target) > 0 {
+		rv = append(rv,
+			&types.Testcase{SourceNode: "netperf-w1", DestinationNode: target, Label: "69 iperf TCP. Egress to external target", Type: iperfTcpTest, External: true},
+			&types.Testcase{SourceNode: "netperf-w1", DestinationNode: target, Label: "70 iperf TCP. Ingress from external target", Type: iperfTcpTest, External: true, Params: types.Params{Direction: types.DirectionDownload}},
+			&types.Testcase{SourceNode: "netperf-w1", DestinationNode: target, Label: "71 iperf UDP. Egress to external target", Type: iperfUdpTest, External: true, Sweep: rateSweep, LossThreshold: udpLossThreshold},
+		)
 	}
-	integration.PrettyPrint(buffer)
+	if len(netperfTarget) > 0 {
+		rv = append(rv,
+			&types.Testcase{SourceNode: "netperf-w1", DestinationNode: netperfTarget, Label: "72 netperf. Egress to external target", Type: netperfTest, External: true},
+		)
+	}
+	return
+}
 
-	resultsBuffer := fmt.Sprintf("%s\n", buffer)
-	for _, label := range dataPointKeys {
-		buffer = fmt.Sprintf("%-45s%s", label, csvSeparator)
-		points := dataPoints[label]
-		buffer = buffer + fmt.Sprintf("%f%s", getMax(points), csvSeparator)
-		for _, p := range points {
-			buffer = buffer + fmt.Sprintf("%s%s", p.Bandwidth, csvSeparator)
-		}
-		integration.PrettyPrint(buffer)
-		resultsBuffer += fmt.Sprintf("%s\n", buffer)
+// splitExternalTarget splits the host[:port] of an external server, the port defaults to the one of the test type
+func splitExternalTarget(target, defaultPort string) (host, port string) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return strings.Trim(target, "[]"), defaultPort
 	}
+	return host, port
+}
 
-	integration.PrettyPrint(csvEndDataMarker)
-	resultsBuffer += fmt.Sprintf("%s\n", csvEndDataMarker)
-	writeOutputFile(resultCaptureFile, resultsBuffer)
+// serverPort returns the port the servers of a test type listen on in the destination pod
+func serverPort(testType int) string {
+	switch testType {
+	case iperfTcpTest, iperfUdpTest:
+		return iperf3ServerPort
+	case netperfTest:
+		return netperfServerPort
+	case nativeTcpTest, nativeUdpTest:
+		return nativeServerPort
+	case latencyTcpTest, latencyUdpTest, connRateTest, connScaleTest:
+		return latencyServerPort
+	case httpTest:
+		return httpServerPort
+	case grpcUnaryTest, grpcStreamTest:
+		return grpcServerPort
+	}
+	// The DNS test resolves the service name of the destination unless names are configured
+	return ""
 }
 
-func getMax(points []types.Point) float64 {
-	var max float64
-	for _, p := range points {
-		fv, _ := strconv.ParseFloat(p.Bandwidth, 64)
-		if fv > max {
-			max = fv
+// needsServicePorts reports whether the Virtual IP testcase connects to another port of the worker Service than
+// the ones of iperf3 and netperf, the ports of the native servers or of the further iperf3 servers of concurrent flows
+func needsServicePorts(testcase *types.Testcase) bool {
+	if !testcase.ClusterIP || testcase.Type == dnsTest {
+		return false
+	}
+	if port := serverPort(testcase.Type); port != iperf3ServerPort && port != netperfServerPort {
+		return true
+	}
+	flows := testcaseFlows(testcase)
+	for k := range flows {
+		if destinationSlot(flows, k) > 0 {
+			return true
 		}
 	}
-
-	return max
+	return false
 }
 
 func writeOutputFile(filename, data string) {
-	fd, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0666)
+	fd, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
-		integration.PrettyPrintWarn("Failed to open existing file %s: %s", filename, err)
+		integration.PrettyPrintWarn("Failed to open file %s: %s", filename, err)
 		return
 	}
 	defer fd.Close()
@@ -288,20 +613,88 @@ func allWorkersIdle() bool {
 	return true
 }
 
-func getWorkerPodName(worker string) string {
-	return workerStateMap[worker].Worker
+// getWorkerPodIP returns the pod IP of the worker in the address family, the primary pod IP if no family is given
+func getWorkerPodIP(worker, family string) string {
+	if len(family) == 0 {
+		return workerStateMap[worker].IP
+	}
+	return pickAddress(workerStateMap[worker].IPs, family)
 }
 
-func getWorkerPodIP(worker string) string {
-	return workerStateMap[worker].IP
+// flowTarget returns the host and port a flow of the testcase connects to. Every further flow to the same
+// destination of a job gets a server of its own, as an iperf3 server only serves a single client at a time.
+func flowTarget(testcase *types.Testcase, job *types.Job, destination string, slot int) (host, port string) {
+	port = serverPort(testcase.Type)
+	if slot > 0 && (testcase.Type == iperfTcpTest || testcase.Type == iperfUdpTest) {
+		port = strconv.Itoa(iperf3FlowBasePort + slot)
+	}
+
+	switch {
+	case testcase.External:
+		return splitExternalTarget(destination, port)
+	case testcase.Type == dnsTest:
+		return workerStateMap[destination].ServiceName, port
+	case testcase.NodePort:
+		state := workerStateMap[destination]
+		return pickAddress(state.NodeIPs, job.Params.Family), state.NodePorts[port]
+	case testcase.ClusterIP:
+		return getWorkerServiceAddress(testcase, destination, job.Params.Family), port
+	default:
+		return getWorkerPodIP(destination, job.Params.Family), port
+	}
 }
 
-func registerDataPoint(label string, mss int, value string, index int) {
+// getWorkerServiceAddress returns the host of a Virtual IP testcase, the configured address or the ClusterIP
+// of the destination in the address family of the job
+func getWorkerServiceAddress(testcase *types.Testcase, destination, family string) string {
+	if len(testcase.ServiceAddress) > 0 {
+		return testcase.ServiceAddress
+	}
+	return pickAddress(workerStateMap[destination].ServiceIPs, family)
+}
+
+// checkWorkerAddresses returns what a worker registered without although its jobs need it, the Service or
+// an address family, instead of letting every one of them fail against an unresolvable host later on.
+// The family is a parameter of the job, as it can be swept.
+func checkWorkerAddresses(state *types.WorkerState) (problems []string) {
+	seen := make(map[string]bool)
+	problem := func(key, format string, args ...interface{}) {
+		if !seen[key] {
+			seen[key] = true
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	for _, job := range jobs {
+		v := testcases[job.Testcase]
+		if !isDestination(v, state.Worker) || v.Type == dnsTest {
+			continue
+		}
+		family := job.Params.Family
+		switch {
+		case v.ClusterIP && len(v.ServiceAddress) == 0 && len(pickAddress(state.ServiceIPs, family)) == 0:
+			problem("service"+family, "registered without %sClusterIP but testcase '%s' targets its Virtual IP. "+
+				"Create a Service %s selecting the worker pod or set %s on the worker", familyName(family), v.Label, state.ServiceName, EnvWorkerServiceIP)
+		case v.NodePort && len(pickAddress(state.NodeIPs, family)) == 0:
+			problem("node"+family, "registered without %snode IP but testcase '%s' targets its NodePort. "+
+				"Set %s on the worker to the IPs of its node", familyName(family), v.Label, EnvWorkerNodeIPs)
+		case v.NodePort && len(state.NodePorts[serverPort(v.Type)]) == 0:
+			problem("nodeport"+serverPort(v.Type), "registered without NodePort for port %s but testcase '%s' targets it. "+
+				"Create a NodePort Service selecting the worker pod and set %s on the worker", serverPort(v.Type), v.Label, EnvWorkerNodePorts)
+		case !v.ClusterIP && !v.NodePort && len(family) > 0 && len(pickAddress(state.IPs, family)) == 0:
+			problem("pod"+family, "registered without %spod IP but testcase '%s' targets it. "+
+				"Set %s on the worker to all of its pod IPs", familyName(family), v.Label, EnvWorkerPodIPs)
+		}
+	}
+	return
+}
+
+func registerDataPoint(label string, point types.Point) {
+	point.Address = jobs[point.Index].Address
 	if sl, ok := dataPoints[label]; !ok {
-		dataPoints[label] = []types.Point{{Mss: mss, Bandwidth: value, Index: index}}
+		dataPoints[label] = []types.Point{point}
 		dataPointKeys = append(dataPointKeys, label)
 	} else {
-		dataPoints[label] = append(sl, types.Point{Mss: mss, Bandwidth: value, Index: index})
+		dataPoints[label] = append(sl, point)
 	}
 }
 
@@ -314,13 +707,26 @@ func parseIperfTcpBandwidth(output string) string {
 	return defaultBandwithFailed
 }
 
-func parseIperfUdpBandwidth(output string) string {
-	// Parses the output of iperf3 (UDP mode) and grabs the Mbits/sec from the output
-	match := iperfUDPOutputRegexp.FindStringSubmatch(output)
-	if match != nil && len(match) > 1 {
-		return match[1]
+// splitIperfBidirOutput separates the lines of a bidirectional iperf3 run into the ones
+// sent by the client (TX-C, upload) and the ones received by the client (RX-C, download)
+func splitIperfBidirOutput(output string) (tx, rx string) {
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.Contains(line, "[TX-C]"):
+			tx += line + "\n"
+		case strings.Contains(line, "[RX-C]"):
+			rx += line + "\n"
+		}
 	}
-	return defaultBandwithFailed
+	return
+}
+
+func parseIperfTcpStreamBandwidths(output string) (rv []string) {
+	// Parses the output of iperf3 and grabs the Mbits/sec of every single stream from the output
+	for _, match := range iperfTCPStreamOutputRegexp.FindAllStringSubmatch(output, -1) {
+		rv = append(rv, match[1])
+	}
+	return
 }
 
 func parseNetperfBandwidth(output string) string {
@@ -331,3 +737,15 @@ func parseNetperfBandwidth(output string) string {
 	}
 	return defaultBandwithFailed
 }
+
+func parseIperfUdpResult(output string) (bw, jitter, loss string) {
+	// Parses the output of iperf3 (UDP mode) and grabs Mbits/sec, jitter and loss of the receiver.
+	// Newer iperf3 versions print a sender line before the receiver line, so the last match wins.
+	bw, jitter, loss = defaultBandwithFailed, defaultBandwithFailed, defaultBandwithFailed
+	matches := iperfUDPOutputRegexp.FindAllStringSubmatch(output, -1)
+	if len(matches) > 0 {
+		match := matches[len(matches)-1]
+		bw, jitter, loss = match[1], match[2], match[3]
+	}
+	return
+}
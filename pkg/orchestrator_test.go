@@ -0,0 +1,226 @@
+package pkg
+
+import (
+	"github.com/mrahbar/k8s-nptest/types"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testdata/iperf3_bidir.txt is the verbose output of iperf3 3.9 with -P 2 --bidir
+func TestSplitIperfBidirOutput(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/iperf3_bidir.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, rx := splitIperfBidirOutput(string(data))
+	if strings.Contains(tx, "[RX-C]") || strings.Contains(rx, "[TX-C]") {
+		t.Fatalf("directions mixed up:\n%s\n%s", tx, rx)
+	}
+
+	tests := []struct {
+		direction string
+		output    string
+		bandwidth string
+		streams   []string
+	}{
+		{"upload", tx, "9322", []string{"4671", "4651"}},
+		{"download", rx, "7170", []string{"3595", "3575"}},
+	}
+	for _, tt := range tests {
+		if bw := parseIperfTcpBandwidth(tt.output); bw != tt.bandwidth {
+			t.Errorf("%s: got %s Mbits/sec, want %s", tt.direction, bw, tt.bandwidth)
+		}
+		if streams := parseIperfTcpStreamBandwidths(tt.output); !reflect.DeepEqual(streams, tt.streams) {
+			t.Errorf("%s: got streams %v, want %v", tt.direction, streams, tt.streams)
+		}
+	}
+
+	if cpu := parseIperfCpu(string(data)); cpu == nil || cpu.HostTotal != 62.4 || cpu.RemoteTotal != 71.8 {
+		t.Errorf("unexpected CPU utilization %+v", cpu)
+	}
+}
+
+func TestNeedsServicePorts(t *testing.T) {
+	tests := []struct {
+		testcase types.Testcase
+		want     bool
+	}{
+		{types.Testcase{Label: "iperf Pod IP", Type: iperfTcpTest}, false},
+		{types.Testcase{Label: "iperf Virtual IP", Type: iperfTcpTest, ClusterIP: true}, false},
+		{types.Testcase{Label: "netperf Virtual IP", Type: netperfTest, ClusterIP: true}, false},
+		{types.Testcase{Label: "DNS", Type: dnsTest, ClusterIP: true}, false},
+		{types.Testcase{Label: "native Pod IP", Type: nativeTcpTest}, false},
+		{types.Testcase{Label: "native Virtual IP", Type: nativeTcpTest, ClusterIP: true}, true},
+		{types.Testcase{Label: "latency Virtual IP", Type: latencyTcpTest, ClusterIP: true}, true},
+		{types.Testcase{Label: "HTTP Virtual IP", Type: httpTest, ClusterIP: true}, true},
+		{types.Testcase{Label: "gRPC Virtual IP", Type: grpcUnaryTest, ClusterIP: true}, true},
+		{types.Testcase{Label: "incast Virtual IP", Type: iperfTcpTest, ClusterIP: true, Flows: incastFlows}, true},
+		{types.Testcase{Label: "incast Pod IP", Type: iperfTcpTest, Flows: incastFlows}, false},
+		{types.Testcase{Label: "fan-out Virtual IP", Type: iperfTcpTest, ClusterIP: true, Flows: fanOutFlows}, false},
+	}
+	for _, tt := range tests {
+		if got := needsServicePorts(&tt.testcase); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.testcase.Label, got, tt.want)
+		}
+	}
+}
+
+// useSchedule replaces the schedule and the registered workers of the orchestrator for the test
+func useSchedule(t *testing.T, schedule []*types.Testcase, workers ...*types.WorkerState) {
+	savedTestcases, savedJobs, savedWorkers := testcases, jobs, workerStateMap
+	t.Cleanup(func() {
+		testcases, jobs, workerStateMap = savedTestcases, savedJobs, savedWorkers
+	})
+
+	var err error
+	testcases = schedule
+	if jobs, err = expandJobs(schedule); err != nil {
+		t.Fatal(err)
+	}
+	workerStateMap = make(map[string]*types.WorkerState)
+	for _, state := range workers {
+		workerStateMap[state.Worker] = state
+	}
+}
+
+func TestSweptFamily(t *testing.T) {
+	families := []types.Dimension{{Name: types.ParamFamily, Values: []string{types.FamilyIPv4, types.FamilyIPv6}}}
+	worker := &types.WorkerState{Worker: "netperf-w2", ServiceName: "netperf-w2", IPs: []string{"10.1.0.2", "fd01::2"},
+		ServiceIPs: []string{"10.96.0.2", "fd96::2"}}
+	useSchedule(t, []*types.Testcase{
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "Pod IP", Type: iperfTcpTest, Sweep: families},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "Virtual IP", Type: iperfTcpTest, ClusterIP: true, Sweep: families},
+	}, worker)
+
+	want := []string{"10.1.0.2", "fd01::2", "10.96.0.2", "fd96::2"}
+	for i, job := range jobs {
+		if host, _ := flowTarget(testcases[job.Testcase], job, "netperf-w2", 0); host != want[i] {
+			t.Errorf("job %d of family %s: got host %s, want %s", i, job.Params.Family, host, want[i])
+		}
+	}
+
+	if problems := checkWorkerAddresses(worker); len(problems) > 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+	worker.IPs, worker.ServiceIPs = worker.IPs[:1], worker.ServiceIPs[:1]
+	if problems := checkWorkerAddresses(worker); len(problems) != 2 {
+		t.Errorf("got problems %v, want the missing IPv6 pod IP and ClusterIP", problems)
+	}
+}
+
+func TestSplitExternalTarget(t *testing.T) {
+	tests := []struct {
+		target, host, port string
+	}{
+		{"iperf.example.com", "iperf.example.com", iperf3ServerPort},
+		{"iperf.example.com:5300", "iperf.example.com", "5300"},
+		{"203.0.113.7", "203.0.113.7", iperf3ServerPort},
+		{"203.0.113.7:5300", "203.0.113.7", "5300"},
+		{"2001:db8::7", "2001:db8::7", iperf3ServerPort},
+		{"[2001:db8::7]", "2001:db8::7", iperf3ServerPort},
+		{"[2001:db8::7]:5300", "2001:db8::7", "5300"},
+	}
+	for _, tt := range tests {
+		if host, port := splitExternalTarget(tt.target, iperf3ServerPort); host != tt.host || port != tt.port {
+			t.Errorf("%s: got %s %s, want %s %s", tt.target, host, port, tt.host, tt.port)
+		}
+	}
+}
+
+func TestEgressJobs(t *testing.T) {
+	if testcases := egressTestcases("", ""); len(testcases) != 0 {
+		t.Fatalf("got %d egress testcases without targets", len(testcases))
+	}
+
+	saved := preflightPassed
+	preflightPassed = true
+	defer func() { preflightPassed = saved }()
+	worker := &types.WorkerState{Worker: "netperf-w1", IP: "10.1.0.1"}
+	useSchedule(t, egressTestcases("iperf.example.com:5300", "[2001:db8::7]"), worker)
+
+	// Only the source is a worker, the UDP testcase sweeps the rate
+	if want := 3 + len(rateSweep[0].Values); len(jobs) != want {
+		t.Fatalf("got %d jobs, want %d", len(jobs), want)
+	}
+	for n, job := range jobs {
+		testcase := testcases[job.Testcase]
+		host, port := "iperf.example.com", "5300"
+		if testcase.Type == netperfTest {
+			host, port = "2001:db8::7", netperfServerPort
+		}
+
+		var reply types.WorkItem
+		worker.Idle = true
+		allocateWorkToClient(worker, &reply)
+		item := reply.ClientItem
+		if !reply.IsClientItem || reply.IsMonitorItem || item.Job != n {
+			t.Fatalf("job %d of '%s' not handed out: %+v", n, testcase.Label, reply)
+		}
+		if item.Type != testcase.Type || item.Host != host || item.Port != port || item.Params != job.Params {
+			t.Errorf("job %d of '%s': got %+v, want %s port %s", n, testcase.Label, item, host, port)
+		}
+		if !job.Finished || job.Address != host {
+			t.Errorf("job %d of '%s' finished %v with address %s", n, testcase.Label, job.Finished, job.Address)
+		}
+	}
+}
+
+func TestRegisterAgain(t *testing.T) {
+	saved, savedProblems := preflightPassed, registrationProblems
+	preflightPassed, registrationProblems = true, make(map[string][]string)
+	defer func() { preflightPassed, registrationProblems = saved, savedProblems }()
+	useSchedule(t, []*types.Testcase{
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "Pod IP", Type: iperfTcpTest},
+		{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "Virtual IP", Type: iperfTcpTest, ClusterIP: true},
+	})
+
+	var server NetPerfRpc
+	register := func(data types.Worker) error {
+		var reply types.WorkItem
+		return server.RegisterClient(&data, &reply)
+	}
+	allocate := func(worker string) types.WorkItem {
+		var reply types.WorkItem
+		allocateWorkToClient(workerStateMap[worker], &reply)
+		return reply
+	}
+	w1 := types.Worker{Worker: "netperf-w1", IP: "10.1.0.1"}
+	w2 := types.Worker{Worker: "netperf-w2", IP: "10.1.0.2", ServiceIPs: []string{"10.96.0.2"}}
+	for _, data := range []types.Worker{w1, w2} {
+		if err := register(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if reply := allocate("netperf-w1"); !reply.IsClientItem || reply.ClientItem.Job != 0 {
+		t.Fatalf("first job not handed out: %+v", reply)
+	}
+	if reply := allocate("netperf-w2"); !reply.IsMonitorItem || reply.MonitorItem.Job != 0 {
+		t.Fatalf("first job not monitored: %+v", reply)
+	}
+
+	// The destination comes back as a new pod, the source restarts in place
+	w2.IP, w2.ServiceIPs = "10.1.0.12", []string{"10.96.0.12"}
+	if err := register(w2); err != nil {
+		t.Fatal(err)
+	}
+	if state := workerStateMap["netperf-w2"]; state.IP != w2.IP || !reflect.DeepEqual(state.ServiceIPs, w2.ServiceIPs) || !state.Idle {
+		t.Errorf("state not refreshed: %+v", state)
+	}
+	if err := register(w1); err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].Finished || jobs[0].Assigned[0] || !reflect.DeepEqual(jobs[0].Monitors, []string{"netperf-w2"}) {
+		t.Fatalf("unfinished job not requeued: %+v", jobs[0])
+	}
+	if reply := allocate("netperf-w1"); !reply.IsClientItem || reply.ClientItem.Job != 0 || reply.ClientItem.Host != w2.IP {
+		t.Errorf("first job not handed out again to the new address: %+v", reply)
+	}
+
+	w2.ServiceIPs = nil
+	if err := register(w2); err == nil {
+		t.Error("worker registered again without the ClusterIP its testcases need")
+	}
+}
@@ -0,0 +1,188 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"net"
+	"net/rpc"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Before the first job every worker of the schedule checks its tools and that its own servers listen,
+// the source workers additionally connect to every server port of their destinations. A single problem
+// aborts the run with a report of all of them, instead of surfacing as -1 somewhere in the results.
+
+var preflightReports = make(map[string]*types.PreflightReport) // Workers asked for their checks, nil until they reported
+var registrationProblems = make(map[string][]string)           // Addresses the workers registered without, see checkWorkerAddresses
+var preflightPassed bool
+
+// allocatePreflight hands out the pre-flight checks once all workers of the schedule registered, it reports
+// whether the pre-flight phase is still going on and the reply is set
+func allocatePreflight(worker *types.WorkerState, reply *types.WorkItem) bool {
+	if preflightPassed {
+		return false
+	}
+	reply.IsIdle = true
+	workers := scheduleWorkers()
+	for _, name := range workers {
+		if _, ok := workerStateMap[name]; !ok {
+			return true
+		}
+	}
+	if _, ok := preflightReports[worker.Worker]; ok || !containsString(workers, worker.Worker) {
+		return true
+	}
+
+	targets := preflightTargets(worker.Worker)
+	integration.PrettyPrintInfo("Requesting pre-flight checks from %s with %d targets", worker.Worker, len(targets))
+	preflightReports[worker.Worker] = nil
+	reply.IsIdle = false
+	reply.IsPreflightItem = true
+	reply.PreflightItem = types.PreflightWorkItem{Targets: targets}
+	worker.Idle = false
+	return true
+}
+
+// ReportPreflight records the outcome of the pre-flight checks of a worker. Once all workers reported
+// the schedule starts, or the orchestrator bails out with every problem found.
+func (t *NetPerfRpc) ReportPreflight(data *types.PreflightReport, reply *int) error {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+
+	report := *data
+	preflightReports[data.Worker] = &report
+	var problems int
+	for _, name := range scheduleWorkers() {
+		r, ok := preflightReports[name]
+		if !ok || r == nil {
+			return nil
+		}
+		for _, problem := range registrationProblems[name] {
+			integration.PrettyPrintErr("Worker %s %s", name, problem)
+			problems++
+		}
+		for _, problem := range r.Problems {
+			integration.PrettyPrintErr("Pre-flight check failed on %s: %s", name, problem)
+			problems++
+		}
+	}
+	if problems > 0 {
+		integration.PrettyPrintErr("Pre-flight checks found %d problems, aborting before the first job", problems)
+		os.Exit(1)
+	}
+
+	integration.PrettyPrintOk("Pre-flight checks passed on all workers")
+	preflightPassed = true
+	workChanged.Broadcast()
+	return nil
+}
+
+// scheduleWorkers returns the workers the testcases run on, external servers aside
+func scheduleWorkers() (rv []string) {
+	for _, v := range testcases {
+		for _, flow := range testcaseFlows(v) {
+			if !containsString(rv, flow.SourceNode) {
+				rv = append(rv, flow.SourceNode)
+			}
+			if !v.External && !containsString(rv, flow.DestinationNode) {
+				rv = append(rv, flow.DestinationNode)
+			}
+		}
+	}
+	return
+}
+
+// preflightTargets returns every server port the jobs of the source connect to. The DNS test is
+// left out, its server is the cluster DNS and its port not a TCP port of the destination.
+func preflightTargets(source string) (rv []types.PreflightTarget) {
+	seen := make(map[string]bool)
+	for _, job := range jobs {
+		v := testcases[job.Testcase]
+		if v.Type == dnsTest {
+			continue
+		}
+		flows := testcaseFlows(v)
+		for k, flow := range flows {
+			if flow.SourceNode != source {
+				continue
+			}
+			host, port := flowTarget(v, job, flow.DestinationNode, destinationSlot(flows, k))
+			if address := net.JoinHostPort(host, port); !seen[address] {
+				seen[address] = true
+				rv = append(rv, types.PreflightTarget{Destination: flow.DestinationNode, Host: host, Port: port})
+			}
+		}
+	}
+	return
+}
+
+// runPreflight runs the pre-flight checks of the worker and reports every problem found to the orchestrator
+func runPreflight(client *rpc.Client, item types.PreflightWorkItem) {
+	var lock sync.Mutex
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, path := range []string{iperf3Path, netperfPath, netperfServerPath} {
+		if info, err := os.Stat(path); err != nil {
+			problem("%s", err)
+		} else if info.Mode()&0111 == 0 {
+			problem("%s is not executable", path)
+		}
+	}
+
+	ports := []string{iperf3ServerPort, netperfServerPort, nativeServerPort, latencyServerPort, httpServerPort, grpcServerPort}
+	for slot := 1; slot < iperf3MaxFlows; slot++ {
+		ports = append(ports, strconv.Itoa(iperf3FlowBasePort+slot))
+	}
+	var wg sync.WaitGroup
+	for _, port := range ports {
+		wg.Add(1)
+		go func(port string) {
+			defer wg.Done()
+			// The servers were started right before, give them some time to listen
+			deadline := time.Now().Add(preflightServerWait * time.Second)
+			for {
+				conn, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", port), preflightDialTimeout*time.Second)
+				if err == nil {
+					conn.Close()
+					return
+				}
+				if time.Now().After(deadline) {
+					problem("server on port %s is not listening: %s", port, err)
+					return
+				}
+				time.Sleep(time.Second)
+			}
+		}(port)
+	}
+	for _, target := range item.Targets {
+		wg.Add(1)
+		go func(target types.PreflightTarget) {
+			defer wg.Done()
+			address := net.JoinHostPort(target.Host, target.Port)
+			conn, err := net.DialTimeout("tcp", address, preflightDialTimeout*time.Second)
+			if err != nil {
+				problem("cannot reach %s at %s: %s", target.Destination, address, err)
+				return
+			}
+			conn.Close()
+		}(target)
+	}
+	wg.Wait()
+
+	if len(problems) == 0 {
+		integration.PrettyPrintOk("Pre-flight checks passed with %d targets", len(item.Targets))
+	}
+	var reply int
+	if err := client.Call("NetPerfRpc.ReportPreflight", types.PreflightReport{Worker: clientData.Worker, Problems: problems}, &reply); err != nil {
+		integration.PrettyPrintErr("Error reporting pre-flight checks: %s", err)
+	}
+}
@@ -0,0 +1,524 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func flushDataPointsToCsv() {
+	var buffer string
+	attachUsage()
+
+	// Write the MSS points for the X-axis before dumping all the testcase datapoints
+	for _, label := range dataPointKeys {
+		points := dataPoints[label]
+		if !isMssSweep(points) {
+			continue
+		}
+		buffer = fmt.Sprintf("%-45s%s Maximum%s", "MSS", csvSeparator, csvSeparator)
+		for _, p := range points {
+			buffer = buffer + fmt.Sprintf(" %d%s", p.Params.MSS, csvSeparator)
+		}
+		break
+	}
+	integration.PrettyPrint(buffer)
+
+	resultsBuffer := fmt.Sprintf("%s\n", buffer)
+	for _, label := range dataPointKeys {
+		points := dataPoints[label]
+		if isSweep(points) || isLatency(points) {
+			continue
+		}
+		buffer = fmt.Sprintf("%-45s%s", label, csvSeparator)
+		buffer = buffer + fmt.Sprintf("%f%s", getMax(points), csvSeparator)
+		for _, p := range points {
+			buffer = buffer + fmt.Sprintf("%s%s", p.Bandwidth, csvSeparator)
+		}
+		integration.PrettyPrint(buffer)
+		resultsBuffer += fmt.Sprintf("%s\n", buffer)
+	}
+
+	resultsBuffer += flushSweepsToCsv()
+	resultsBuffer += flushLatenciesToCsv()
+	resultsBuffer += flushDnsToCsv()
+	resultsBuffer += flushFlowsToCsv()
+	resultsBuffer += flushOverheadToCsv()
+	resultsBuffer += flushCpuToCsv()
+	resultsBuffer += flushCountersToCsv()
+
+	integration.PrettyPrint(csvEndDataMarker)
+	resultsBuffer += fmt.Sprintf("%s\n", csvEndDataMarker)
+	writeOutputFile(runFile(resultCsvName), resultsBuffer)
+}
+
+// flushSweepsToCsv writes the testcases which sweep anything else than just the MSS as separate blocks.
+// The last dimension of the sweep is the X-axis, every tuple of the remaining dimensions gets its own rows.
+func flushSweepsToCsv() (resultsBuffer string) {
+	for _, label := range dataPointKeys {
+		points := dataPoints[label]
+		if !isSweep(points) || isLatency(points) {
+			continue
+		}
+		testcase := testcases[jobs[points[0].Index].Testcase]
+		x := testcase.Sweep[len(testcase.Sweep)-1]
+		outer := testcase.Sweep[:len(testcase.Sweep)-1]
+
+		var keys []string
+		groups := make(map[string][]types.Point)
+		for _, p := range points {
+			key := p.Params.Format(outer)
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], p)
+		}
+
+		summaryTitle := "Maximum"
+		if x.Name == types.ParamRate {
+			summaryTitle = fmt.Sprintf("Sustained (loss <= %g%%)", testcase.LossThreshold)
+		}
+		values := make([]string, len(x.Values))
+		for i, v := range x.Values {
+			values[i] = " " + v
+		}
+		resultsBuffer += csvRow(sweepTitles[x.Name], " "+summaryTitle, values)
+
+		for _, key := range keys {
+			group := groups[key]
+			title := strings.TrimSpace(label + " " + key)
+
+			summary := fmt.Sprintf("%f", getMax(group))
+			if x.Name == types.ParamRate {
+				summary = getMaxSustainedRate(group, testcase.LossThreshold)
+			}
+			resultsBuffer += csvRow(title+" Mbits/sec", summary, collect(group, func(p types.Point) string { return p.Bandwidth }))
+
+			if testcase.Type == iperfUdpTest || testcase.Type == nativeUdpTest {
+				resultsBuffer += csvRow(title+" Loss %", "", collect(group, func(p types.Point) string { return p.Loss }))
+				resultsBuffer += csvRow(title+" Jitter ms", "", collect(group, func(p types.Point) string { return p.Jitter }))
+			}
+			if x.Name == types.ParamStreams {
+				resultsBuffer += csvRow(title+" per stream Mbits/sec", "", collect(group, func(p types.Point) string { return strings.Join(p.StreamBandwidths, "/") }))
+			}
+		}
+	}
+	return
+}
+
+// flushLatenciesToCsv writes the percentiles of the latency and HTTP testcases, one row per parameter tuple.
+// The histograms of all repetitions of a tuple are merged before the percentiles are taken.
+func flushLatenciesToCsv() (resultsBuffer string) {
+	header := false
+	for _, label := range dataPointKeys {
+		points := dataPoints[label]
+		if !isLatency(points) {
+			continue
+		}
+		if !header {
+			resultsBuffer += csvRow("Latency (us)", " p50", []string{" p99", " p99.9", " max", " mean", " requests", " errors", " requests/sec"})
+			header = true
+		}
+		testcase := testcases[jobs[points[0].Index].Testcase]
+
+		var keys []string
+		merged := make(map[string]*types.LatencyResult)
+		for _, p := range points {
+			key := p.Params.Format(testcase.Sweep)
+			m, ok := merged[key]
+			if !ok {
+				m = &types.LatencyResult{Histogram: types.NewHistogram(latencyHighest, latencyDigits)}
+				merged[key] = m
+				keys = append(keys, key)
+			}
+			if p.Latency == nil || p.Latency.Histogram == nil {
+				continue
+			}
+			if err := m.Histogram.Merge(p.Latency.Histogram); err != nil {
+				integration.PrettyPrintWarn("Failed to merge latencies of %s: %s", label, err)
+				continue
+			}
+			m.Requests += p.Latency.Requests
+			m.Errors += p.Latency.Errors
+			m.Duration += p.Latency.Duration
+		}
+
+		for _, key := range keys {
+			h := merged[key].Histogram
+			resultsBuffer += csvRow(strings.TrimSpace(label+" "+key), strconv.FormatInt(h.ValueAtPercentile(50), 10), []string{
+				strconv.FormatInt(h.ValueAtPercentile(99), 10),
+				strconv.FormatInt(h.ValueAtPercentile(99.9), 10),
+				strconv.FormatInt(h.Max, 10),
+				fmt.Sprintf("%.1f", h.Mean()),
+				strconv.FormatInt(merged[key].Requests, 10),
+				strconv.FormatInt(merged[key].Errors, 10),
+				fmt.Sprintf("%.1f", requestsPerSecond(merged[key])),
+			})
+		}
+	}
+	return
+}
+
+// flushDnsToCsv writes the lookup outcomes of the DNS testcases in percent of all lookups, one row per parameter tuple.
+// The latency percentiles of the lookups are part of the latency block.
+func flushDnsToCsv() (resultsBuffer string) {
+	header := false
+	for _, label := range dataPointKeys {
+		points := dataPoints[label]
+		if len(points) == 0 || points[0].Dns == nil {
+			continue
+		}
+		if !header {
+			resultsBuffer += csvRow("DNS (% of lookups)", " nxdomain", []string{" timeouts", " failures", " inconsistent", " lookups", " distinct answers"})
+			header = true
+		}
+		testcase := testcases[jobs[points[0].Index].Testcase]
+
+		var keys []string
+		merged := make(map[string]*types.DnsResult)
+		for _, p := range points {
+			if p.Dns == nil {
+				continue
+			}
+			key := p.Params.Format(testcase.Sweep)
+			m, ok := merged[key]
+			if !ok {
+				m = &types.DnsResult{Answers: make(map[string][]string)}
+				merged[key] = m
+				keys = append(keys, key)
+			}
+			m.Lookups += p.Dns.Lookups
+			m.NXDomain += p.Dns.NXDomain
+			m.Timeouts += p.Dns.Timeouts
+			m.Failures += p.Dns.Failures
+			m.Inconsistent += p.Dns.Inconsistent
+			for name, answers := range p.Dns.Answers {
+				for _, answer := range answers {
+					if !containsString(m.Answers[name], answer) {
+						m.Answers[name] = append(m.Answers[name], answer)
+					}
+				}
+			}
+		}
+
+		for _, key := range keys {
+			m := merged[key]
+			distinct := 0
+			for _, answers := range m.Answers {
+				if len(answers) > distinct {
+					distinct = len(answers)
+				}
+			}
+			resultsBuffer += csvRow(strings.TrimSpace(label+" "+key), percentOf(m.NXDomain, m.Lookups), []string{
+				percentOf(m.Timeouts, m.Lookups),
+				percentOf(m.Failures, m.Lookups),
+				percentOf(m.Inconsistent, m.Lookups),
+				strconv.FormatInt(m.Lookups, 10),
+				strconv.Itoa(distinct),
+			})
+		}
+	}
+	return
+}
+
+func percentOf(count, total int64) string {
+	if total == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%.2f", 100*float64(count)/float64(total))
+}
+
+// flushFlowsToCsv writes the aggregate, the fairness and the bandwidth of every single flow of the multi-flow testcases,
+// one row per job
+func flushFlowsToCsv() (resultsBuffer string) {
+	header := false
+	for _, label := range dataPointKeys {
+		points := dataPoints[label]
+		if len(points) == 0 || len(points[0].Flows) == 0 {
+			continue
+		}
+		testcase := testcases[jobs[points[0].Index].Testcase]
+		if !header {
+			resultsBuffer += csvRow("Flows (Mbits/sec)", " aggregate", []string{" fairness", " start skew (ms)", " flows"})
+			header = true
+		}
+		for _, p := range points {
+			cells := []string{p.Fairness, p.StartSkew}
+			for _, f := range p.Flows {
+				cells = append(cells, fmt.Sprintf("%s>%s %s", f.SourceNode, f.DestinationNode, f.Bandwidth))
+			}
+			resultsBuffer += csvRow(strings.TrimSpace(label+" "+p.Params.Format(testcase.Sweep)), p.Bandwidth, cells)
+		}
+	}
+	return
+}
+
+// flushOverheadToCsv writes the overhead of every data point of the main table relative to the data point of the
+// host to host testcase with the same type, direction, parameters and node locality. Points without such a
+// baseline, e.g. of same node paths while the host workers run on different nodes, are left out.
+func flushOverheadToCsv() (resultsBuffer string) {
+	type baselineKey struct {
+		testType  int
+		direction string
+		locality  string
+		params    types.Params
+	}
+	keyOf := func(testcase *types.Testcase, p types.Point) baselineKey {
+		return baselineKey{testType: testcase.Type, direction: p.Direction, locality: nodeLocality(testcase), params: p.Params}
+	}
+
+	baselines := make(map[baselineKey]float64)
+	for _, label := range dataPointKeys {
+		points := dataPoints[label]
+		if len(points) == 0 || isSweep(points) || isLatency(points) {
+			continue
+		}
+		testcase := testcases[jobs[points[0].Index].Testcase]
+		if !isHostBaseline(testcase) || len(nodeLocality(testcase)) == 0 {
+			continue
+		}
+		for _, p := range points {
+			key := keyOf(testcase, p)
+			if _, ok := baselines[key]; !ok {
+				baselines[key], _ = strconv.ParseFloat(p.Bandwidth, 64)
+			}
+		}
+	}
+
+	header := false
+	for _, label := range dataPointKeys {
+		points := dataPoints[label]
+		if len(points) == 0 || isSweep(points) || isLatency(points) {
+			continue
+		}
+		testcase := testcases[jobs[points[0].Index].Testcase]
+		if isHostBaseline(testcase) || isMultiFlow(testcase) || len(nodeLocality(testcase)) == 0 {
+			continue
+		}
+		for _, p := range points {
+			baseline := baselines[keyOf(testcase, p)]
+			bw, err := strconv.ParseFloat(p.Bandwidth, 64)
+			if baseline <= 0 || err != nil || bw < 0 {
+				continue
+			}
+			if !header {
+				resultsBuffer += csvRow("Overhead vs host network", " overhead %", []string{" Mbits/sec", " host Mbits/sec"})
+				header = true
+			}
+			resultsBuffer += csvRow(strings.TrimSpace(label+" "+p.Params.Format(testcase.Sweep)), fmt.Sprintf("%.1f", (baseline-bw)/baseline*100), []string{
+				p.Bandwidth,
+				fmt.Sprintf("%f", baseline),
+			})
+		}
+	}
+	return
+}
+
+// flushCpuToCsv writes the cores the client and server workers kept busy during every job together with
+// the bandwidth per busy core, one row per data point
+func flushCpuToCsv() (resultsBuffer string) {
+	header := false
+	for _, label := range dataPointKeys {
+		testcase := testcases[jobs[dataPoints[label][0].Index].Testcase]
+		for _, p := range dataPoints[label] {
+			if len(p.Cpu) == 0 {
+				continue
+			}
+			if !header {
+				resultsBuffer += csvRow("CPU (cores)", " Mbits/sec", []string{" client busy", " client softirq", " Mbits/sec per client core",
+					" server busy", " server softirq", " Mbits/sec per server core", " iperf3 host %", " iperf3 remote %"})
+				header = true
+			}
+			clientBusy, clientSoftirq := roleCores(p, types.RoleClient)
+			serverBusy, serverSoftirq := roleCores(p, types.RoleServer)
+			iperfHost, iperfRemote := defaultBandwithFailed, defaultBandwithFailed
+			if p.IperfCpu != nil {
+				iperfHost, iperfRemote = fmt.Sprintf("%.1f", p.IperfCpu.HostTotal), fmt.Sprintf("%.1f", p.IperfCpu.RemoteTotal)
+			}
+			resultsBuffer += csvRow(strings.TrimSpace(label+" "+p.Params.Format(testcase.Sweep)), p.Bandwidth, []string{
+				fmt.Sprintf("%.2f", clientBusy), fmt.Sprintf("%.2f", clientSoftirq), perCore(p, clientBusy),
+				fmt.Sprintf("%.2f", serverBusy), fmt.Sprintf("%.2f", serverSoftirq), perCore(p, serverBusy),
+				iperfHost, iperfRemote,
+			})
+		}
+	}
+	return
+}
+
+// flushCountersToCsv writes the deltas of the interface and network stack counters on the client and the server side
+// of every job, one row per data point
+func flushCountersToCsv() (resultsBuffer string) {
+	header := false
+	for _, label := range dataPointKeys {
+		testcase := testcases[jobs[dataPoints[label][0].Index].Testcase]
+		for _, p := range dataPoints[label] {
+			if len(p.Counters) == 0 {
+				continue
+			}
+			if !header {
+				var cells []string
+				for _, role := range []string{types.RoleClient, types.RoleServer} {
+					cells = append(cells, " "+role+" retransmits", " "+role+" tcp timeouts", " "+role+" drops", " "+role+" errors",
+						" "+role+" udp buffer errors", " "+role+" NET_RX softirqs")
+				}
+				resultsBuffer += csvRow("Stack counters (deltas)", " Mbits/sec", cells)
+				header = true
+			}
+			var cells []string
+			for _, role := range []string{types.RoleClient, types.RoleServer} {
+				total, snmp, softirqs := roleCounters(p, role)
+				cells = append(cells,
+					strconv.FormatInt(snmp["Tcp.RetransSegs"], 10),
+					strconv.FormatInt(snmp["TcpExt.TCPTimeouts"], 10),
+					strconv.FormatInt(total.RxDrops+total.TxDrops, 10),
+					strconv.FormatInt(total.RxErrors+total.TxErrors, 10),
+					strconv.FormatInt(snmp["Udp.RcvbufErrors"]+snmp["Udp.SndbufErrors"], 10),
+					strconv.FormatInt(softirqs["NET_RX"], 10),
+				)
+			}
+			resultsBuffer += csvRow(strings.TrimSpace(label+" "+p.Params.Format(testcase.Sweep)), p.Bandwidth, cells)
+		}
+	}
+	return
+}
+
+// isHostBaseline reports whether the testcase runs between two host network workers without any Service in between
+func isHostBaseline(testcase *types.Testcase) bool {
+	source, ok := workerStateMap[testcase.SourceNode]
+	if !ok || !source.HostNetwork {
+		return false
+	}
+	destination, ok := workerStateMap[testcase.DestinationNode]
+	return ok && destination.HostNetwork && !testcase.ClusterIP && !testcase.NodePort
+}
+
+// Node localities of the two workers of a testcase
+const (
+	nodeSame   = "same"
+	nodeRemote = "remote"
+)
+
+// nodeLocality returns whether both workers of the testcase run on the same node or on different ones, empty if
+// unknown. Pod workers know their node from their node IPs, host network workers also from their own IPs.
+func nodeLocality(testcase *types.Testcase) string {
+	if testcase.SourceNode == testcase.DestinationNode {
+		return nodeSame
+	}
+	source, ok := workerStateMap[testcase.SourceNode]
+	if !ok {
+		return ""
+	}
+	destination, ok := workerStateMap[testcase.DestinationNode]
+	if !ok {
+		return ""
+	}
+	a, b := nodeAddresses(source), nodeAddresses(destination)
+	if len(a) == 0 || len(b) == 0 {
+		return ""
+	}
+	for _, ip := range a {
+		if containsString(b, ip) {
+			return nodeSame
+		}
+	}
+	return nodeRemote
+}
+
+func nodeAddresses(state *types.WorkerState) []string {
+	if state.HostNetwork {
+		return append(append([]string{}, state.NodeIPs...), state.IPs...)
+	}
+	return state.NodeIPs
+}
+
+// collectResults returns every data point together with its testcase and full parameter tuple
+func collectResults() (results []types.Result) {
+	for _, label := range dataPointKeys {
+		for _, p := range dataPoints[label] {
+			testcase := testcases[jobs[p.Index].Testcase]
+			results = append(results, types.Result{
+				Label:           testcase.Label,
+				SourceNode:      testcase.SourceNode,
+				DestinationNode: testcase.DestinationNode,
+				ClusterIP:       testcase.ClusterIP,
+				NodePort:        testcase.NodePort,
+				Egress:          testcase.External,
+				Type:            testcase.Type,
+				Family:          addressFamily(p.Address),
+				Environments:    testcaseEnvironments(testcase),
+				Parameters:      p.Params.Format(testcase.Sweep),
+				Point:           p,
+			})
+		}
+	}
+	return
+}
+
+// writeResultsJson writes the results to a single document, so reports can pivot on any sweep dimension
+func writeResultsJson(results []types.Result) {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		integration.PrettyPrintWarn("Failed to marshal results: %s", err)
+		return
+	}
+	writeOutputFile(runFile(resultJsonName), string(data)+"\n")
+}
+
+// csvRow prints and returns a single line of the csv output
+func csvRow(title, summary string, cells []string) string {
+	buffer := fmt.Sprintf("%-45s%s%s%s", title, csvSeparator, summary, csvSeparator)
+	for _, c := range cells {
+		buffer = buffer + fmt.Sprintf("%s%s", c, csvSeparator)
+	}
+	integration.PrettyPrint("%s", buffer)
+	return fmt.Sprintf("%s\n", buffer)
+}
+
+func collect(points []types.Point, value func(types.Point) string) []string {
+	rv := make([]string, len(points))
+	for i, p := range points {
+		rv[i] = value(p)
+	}
+	return rv
+}
+
+// getMaxSustainedRate returns the highest offered rate below the first one, in ascending order, whose loss
+// exceeded the threshold or which failed, a higher rate passing by chance does not count as sustained
+func getMaxSustainedRate(points []types.Point, threshold float64) string {
+	sorted := make([]types.Point, 0, len(points))
+	rates := make(map[string]float64)
+	for _, p := range points {
+		if rate, err := parseRate(p.Params.Rate); err == nil {
+			sorted = append(sorted, p)
+			rates[p.Params.Rate] = rate
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return rates[sorted[i].Params.Rate] < rates[sorted[j].Params.Rate] })
+
+	sustained := defaultBandwithFailed
+	for _, p := range sorted {
+		// A failed step keeps the failed marker as loss and bandwidth
+		loss, err := strconv.ParseFloat(p.Loss, 64)
+		if err != nil || loss < 0 || loss > threshold || p.Bandwidth == defaultBandwithFailed {
+			break
+		}
+		sustained = p.Params.Rate
+	}
+	return sustained
+}
+
+func getMax(points []types.Point) float64 {
+	var max float64
+	for _, p := range points {
+		fv, _ := strconv.ParseFloat(p.Bandwidth, 64)
+		if fv > max {
+			max = fv
+		}
+	}
+
+	return max
+}
@@ -0,0 +1,35 @@
+package pkg
+
+import (
+	"github.com/mrahbar/k8s-nptest/types"
+	"testing"
+)
+
+func TestGetMaxSustainedRate(t *testing.T) {
+	step := func(rate, bandwidth, loss string) types.Point {
+		return types.Point{Params: types.Params{Rate: rate}, Bandwidth: bandwidth, Loss: loss}
+	}
+	failed := func(rate string) types.Point {
+		return step(rate, defaultBandwithFailed, defaultBandwithFailed)
+	}
+
+	tests := []struct {
+		name   string
+		points []types.Point
+		want   string
+	}{
+		{"all clean", []types.Point{step("100M", "100", "0"), step("1G", "990", "0.05"), step("10G", "4000", "0.1")}, "10G"},
+		{"lossy step stops the scan", []types.Point{step("100M", "100", "0"), step("1G", "900", "2.5"), step("10G", "4000", "0")}, "100M"},
+		{"failed step stops the scan", []types.Point{step("100M", "100", "0"), failed("1G"), step("10G", "4000", "0")}, "100M"},
+		{"failed bandwidth with parsed loss", []types.Point{step("100M", "100", "0"), step("1G", defaultBandwithFailed, "0")}, "100M"},
+		{"all failed", []types.Point{failed("100M"), failed("1G"), failed("10G")}, defaultBandwithFailed},
+		{"first step lossy", []types.Point{step("100M", "90", "5"), step("1G", "990", "0")}, defaultBandwithFailed},
+		{"unsorted sweep order", []types.Point{step("10G", "4000", "3"), step("100M", "100", "0"), step("1G", "990", "0")}, "1G"},
+		{"no points", nil, defaultBandwithFailed},
+	}
+	for _, tt := range tests {
+		if got := getMaxSustainedRate(tt.points, udpLossThreshold); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Every run writes into a directory of its own below the base path, named by its run ID, i.e. its UTC start
+// time. A resumed run continues in the directory of the checkpoint. The directory holds the raw output of
+// every job, the csv, the JSON, a manifest, the plan and the log of the orchestrator, the latest symlink next
+// to it points to the most recent run.
+
+// planSettings are the environment variables of the orchestrator which shape the schedule
+var planSettings = []string{EnvAddressFamilies, EnvNodePortTests, EnvHostNetworkTests, EnvEgressTarget, EnvEgressNetperfTarget, EnvRunTags}
+
+var runBaseDir string
+var runDir string
+
+// initializeRunDirectory creates the directory of the current run, its empty result files, the manifest and
+// the plan, copies the log into it and points the latest symlink to it
+func initializeRunDirectory(base string) {
+	runBaseDir, runDir = base, filepath.Join(base, currentRun.ID)
+	if err := os.MkdirAll(filepath.Join(runDir, jobOutputDir), 0755); err != nil {
+		integration.PrettyPrintErr("Failed to create result directory: %s", err)
+		os.Exit(2)
+	}
+	initializeOutputFiles(runFile(resultCsvName))
+	initializeOutputFiles(runFile(resultJsonName))
+	writeManifest()
+	writePlan()
+
+	// A resumed run appends to the log of the interrupted one
+	if fd, err := os.OpenFile(runFile(logName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+		integration.PrettyPrintWarn("Failed to open log file: %s", err)
+	} else {
+		integration.SetOutput(io.MultiWriter(os.Stdout, fd))
+	}
+
+	// The symlink is replaced through a temporary one, so it never dangles
+	link := filepath.Join(base, latestLink)
+	if err := os.Remove(link + ".tmp"); err != nil && !os.IsNotExist(err) {
+		integration.PrettyPrintWarn("Failed to update %s: %s", link, err)
+	}
+	if err := os.Symlink(currentRun.ID, link+".tmp"); err != nil {
+		integration.PrettyPrintWarn("Failed to update %s: %s", link, err)
+	} else if err := os.Rename(link+".tmp", link); err != nil {
+		integration.PrettyPrintWarn("Failed to update %s: %s", link, err)
+	}
+	integration.PrettyPrintInfo("Writing results of run %s to %s", currentRun.ID, runDir)
+}
+
+// runFile returns the path of a file in the directory of the current run
+func runFile(name string) string {
+	return filepath.Join(runDir, name)
+}
+
+// jobOutputName returns the name of the raw output file of a job relative to the directory of the run
+func jobOutputName(index int) string {
+	return filepath.Join(jobOutputDir, fmt.Sprintf("%04d.txt", index+1))
+}
+
+// resetJobOutput empties the raw output file of a job, so a job which runs again does not append to its partial output
+func resetJobOutput(index int) {
+	if err := os.Truncate(runFile(jobOutputName(index)), 0); err != nil && !os.IsNotExist(err) {
+		integration.PrettyPrintWarn("Failed to reset output of job %d: %s", index+1, err)
+	}
+}
+
+// writeManifest lists the files of the run, the fingerprints of the workers are added once the run completed
+func writeManifest() {
+	manifest := types.Manifest{Run: currentRun, Csv: resultCsvName, Json: resultJsonName}
+	for n, job := range jobs {
+		testcase := testcases[job.Testcase]
+		manifest.Jobs = append(manifest.Jobs, types.ManifestJob{Index: n, Label: testcase.Label,
+			Parameters: job.Params.Format(testcase.Sweep), Output: jobOutputName(n)})
+	}
+	if datapointsFlushed {
+		manifest.Workers = make(map[string]types.Fingerprint)
+		for name, state := range workerStateMap {
+			manifest.Workers[name] = state.Fingerprint
+		}
+	}
+	if err := writeJsonFile(runFile(manifestName), manifest); err != nil {
+		integration.PrettyPrintWarn("Failed to write manifest: %s", err)
+	}
+}
+
+// writePlan records the testcases of the run and the settings they were derived from
+func writePlan() {
+	plan := types.Plan{Settings: make(map[string]string), Testcases: testcases}
+	for _, name := range planSettings {
+		if value, ok := os.LookupEnv(name); ok {
+			plan.Settings[name] = value
+		}
+	}
+	if err := writeJsonFile(runFile(planName), plan); err != nil {
+		integration.PrettyPrintWarn("Failed to write plan: %s", err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package pkg
+
+import "syscall"
+
+// mssControl returns a dialer control function which sets TCP_MAXSEG on the socket before it connects
+func mssControl(mss int) func(network, address string, c syscall.RawConn) error {
+	if mss <= 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG, mss)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package pkg
+
+import "syscall"
+
+// mssControl is a no-op outside of linux, the MSS is left to the kernel
+func mssControl(mss int) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"net/rpc"
+	"time"
+)
+
+// Every source worker fetches and sets up its flows on its own, so the flows of a multi-flow job
+// would start apart by however long that takes. A job can instead start all of its flows together, either at a scheduled
+// wall clock time or once all of them arrived at a barrier of the orchestrator.
+
+// barrier releases all flows of a job at once as soon as the last one arrived
+type barrier struct {
+	arrived int
+	total   int
+	release chan struct{}
+}
+
+var barriers = make(map[int]*barrier)
+
+// WaitBarrier blocks until all flows of the job arrived at its barrier, or the barrier timed out
+func (t *NetPerfRpc) WaitBarrier(data *types.BarrierRequest, reply *int) error {
+	globalLock.Lock()
+	if data.Job < 0 || data.Job >= len(jobs) {
+		globalLock.Unlock()
+		return fmt.Errorf("unknown job %d", data.Job)
+	}
+	b, ok := barriers[data.Job]
+	if !ok {
+		b = &barrier{total: len(jobs[data.Job].Assigned), release: make(chan struct{})}
+		barriers[data.Job] = b
+	}
+	b.arrived++
+	if b.arrived == b.total {
+		close(b.release)
+		// The released flows hold on to the barrier itself
+		delete(barriers, data.Job)
+	}
+	globalLock.Unlock()
+
+	select {
+	case <-b.release:
+	case <-time.After(barrierTimeout * time.Second):
+		integration.PrettyPrintWarn("Start barrier of job %d timed out, flow %d of worker %s starts without the others", data.Job, data.Flow, data.Worker)
+	}
+	return nil
+}
+
+// scheduleStart sets the start of a job with a scheduled start, far enough ahead for all sources to pick up their flows
+func scheduleStart(testcase *types.Testcase, job *types.Job) {
+	if testcase.Start == types.StartScheduled && job.StartAt.IsZero() {
+		job.StartAt = time.Now().Add(scheduledStartDelay * time.Second)
+	}
+}
+
+// waitForStart holds a client item back until the coordinated start of its job
+func waitForStart(client *rpc.Client, item types.IperfClientWorkItem) {
+	switch item.Start {
+	case types.StartScheduled:
+		integration.PrettyPrintInfo("Waiting for the scheduled start of flow %d at %s", item.Flow, item.StartAt.Format(time.RFC3339Nano))
+		time.Sleep(time.Until(item.StartAt))
+	case types.StartBarrier:
+		integration.PrettyPrintInfo("Waiting at the start barrier with flow %d", item.Flow)
+		var reply int
+		if err := client.Call("NetPerfRpc.WaitBarrier", types.BarrierRequest{Worker: clientData.Worker, Job: item.Job, Flow: item.Flow}, &reply); err != nil {
+			integration.PrettyPrintErr("Error waiting at start barrier: %s", err)
+		}
+	}
+}
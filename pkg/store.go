@@ -0,0 +1,302 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// The results store keeps every completed run as <run ID>.json in a directory on a mounted volume, together
+// with a catalogue of all runs and their tags. The nptests binary lists, shows and deletes runs and follows
+// a testcase across runs, e.g. to compare CNIs or versions.
+//
+// Plain JSON files keep the binary on the standard library and the runs readable with any tool. The store sees
+// a handful of writes per run: the orchestrator adds a run once it completed and the delete command removes one.
+// Every file is replaced through a temporary file, and a lock file serializes the updates of the catalogue.
+
+const (
+	catalogueFile = "catalogue.json"
+	storeLockFile = "catalogue.lock"
+)
+
+var currentRun types.RunSummary
+
+// ResultStoreDir returns the directory of the results store, an empty one disables the store
+func ResultStoreDir() string {
+	if dir, ok := os.LookupEnv(EnvResultStore); ok {
+		return dir
+	}
+	return resultStoreDir
+}
+
+// newRun identifies the run by its start time and carries the tags given as comma separated key=value pairs
+func newRun(tags string) (types.RunSummary, error) {
+	run := types.RunSummary{Started: time.Now().UTC(), Tags: make(map[string]string)}
+	run.ID = run.Started.Format("20060102-150405")
+	var err error
+	run.Tags, err = parseTags(strings.Split(tags, ","))
+	return run, err
+}
+
+// parseTags parses key=value pairs, empty entries are skipped
+func parseTags(pairs []string) (map[string]string, error) {
+	rv := make(map[string]string)
+	for _, pair := range pairs {
+		if pair = strings.TrimSpace(pair); len(pair) == 0 {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", pair)
+		}
+		rv[kv[0]] = kv[1]
+	}
+	return rv, nil
+}
+
+// storeRun saves the results of the completed run and adds it to the catalogue
+func storeRun(results []types.Result) {
+	dir := ResultStoreDir()
+	if len(dir) == 0 {
+		return
+	}
+	run := types.Run{RunSummary: currentRun, Results: results}
+	run.Points = len(results)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		integration.PrettyPrintWarn("Failed to create results store %s: %s", dir, err)
+		return
+	}
+	if err := writeJsonFile(filepath.Join(dir, run.ID+".json"), run); err != nil {
+		integration.PrettyPrintWarn("Failed to store run %s: %s", run.ID, err)
+		return
+	}
+
+	unlock, err := lockStore(dir)
+	if err != nil {
+		integration.PrettyPrintWarn("Failed to add run %s to the catalogue: %s", run.ID, err)
+		return
+	}
+	defer unlock()
+	catalogue, err := loadCatalogue(dir)
+	if err != nil {
+		integration.PrettyPrintWarn("Failed to add run %s to the catalogue: %s", run.ID, err)
+		return
+	}
+	catalogue = append(removeRun(catalogue, run.ID), run.RunSummary)
+	sort.Slice(catalogue, func(i, j int) bool { return catalogue[i].Started.Before(catalogue[j].Started) })
+	if err := writeJsonFile(filepath.Join(dir, catalogueFile), catalogue); err != nil {
+		integration.PrettyPrintWarn("Failed to add run %s to the catalogue: %s", run.ID, err)
+		return
+	}
+	integration.PrettyPrintOk("Stored run %s with %d results in %s", run.ID, run.Points, dir)
+}
+
+func loadCatalogue(dir string) (rv []types.RunSummary, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, catalogueFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &rv)
+	return
+}
+
+func loadRun(dir, id string) (*types.Run, error) {
+	if len(id) == 0 || filepath.Base(id) != id || id+".json" == catalogueFile {
+		return nil, fmt.Errorf("invalid run ID %q", id)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var run types.Run
+	err = json.Unmarshal(data, &run)
+	return &run, err
+}
+
+func removeRun(catalogue []types.RunSummary, id string) (rv []types.RunSummary) {
+	for _, run := range catalogue {
+		if run.ID != id {
+			rv = append(rv, run)
+		}
+	}
+	return
+}
+
+// writeJsonFile writes through a temporary file, so readers never see a partially written file
+func writeJsonFile(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// matchesTags reports whether the run carries all of the tags
+func matchesTags(run types.RunSummary, tags map[string]string) bool {
+	for k, v := range tags {
+		if run.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func formatTags(tags map[string]string) string {
+	var pairs []string
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// StoreCommand runs a command on the results store and returns the exit code of the binary:
+//
+//	list [key=value...]                  runs carrying all of the tags
+//	show <run ID>                        all results of a run
+//	delete <run ID>                      removes a run
+//	trend <label> [mss] [key=value...]   a testcase across the runs carrying all of the tags
+func StoreCommand(dir string, args []string) int {
+	if len(dir) == 0 {
+		integration.PrettyPrintErr("No results store configured, set %s or -store", EnvResultStore)
+		return 1
+	}
+	var err error
+	switch {
+	case args[0] == "list":
+		err = listRuns(dir, args[1:])
+	case args[0] == "show" && len(args) == 2:
+		err = showRun(dir, args[1])
+	case args[0] == "delete" && len(args) == 2:
+		err = deleteRun(dir, args[1])
+	case args[0] == "trend" && len(args) >= 2:
+		err = showTrend(dir, args[1], args[2:])
+	default:
+		err = fmt.Errorf("usage: list [key=value...] | show <run ID> | delete <run ID> | trend <label> [mss] [key=value...]")
+	}
+	if err != nil {
+		integration.PrettyPrintErr("%s", err)
+		return 1
+	}
+	return 0
+}
+
+func listRuns(dir string, args []string) error {
+	tags, err := parseTags(args)
+	if err != nil {
+		return err
+	}
+	catalogue, err := loadCatalogue(dir)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tSTARTED\tFINISHED\tRESULTS\tTAGS")
+	for _, run := range catalogue {
+		if matchesTags(run, tags) {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", run.ID, run.Started.Format(time.RFC3339), run.Finished.Format(time.RFC3339), run.Points, formatTags(run.Tags))
+		}
+	}
+	return w.Flush()
+}
+
+func showRun(dir, id string) error {
+	run, err := loadRun(dir, id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Run %s from %s till %s, tags %s\n", run.ID, run.Started.Format(time.RFC3339), run.Finished.Format(time.RFC3339), formatTags(run.Tags))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LABEL\tPARAMETERS\tDIRECTION\tRESULT")
+	for _, r := range run.Results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Label, r.Parameters, r.Direction, formatResult(r.Point))
+	}
+	return w.Flush()
+}
+
+func deleteRun(dir, id string) error {
+	if _, err := loadRun(dir, id); err != nil {
+		return err
+	}
+	unlock, err := lockStore(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	catalogue, err := loadCatalogue(dir)
+	if err != nil {
+		return err
+	}
+	if err := writeJsonFile(filepath.Join(dir, catalogueFile), removeRun(catalogue, id)); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, id+".json")); err != nil {
+		return err
+	}
+	integration.PrettyPrintOk("Deleted run %s", id)
+	return nil
+}
+
+// showTrend prints the results of a testcase label in every run carrying the tags, optionally only the ones of an MSS
+func showTrend(dir, label string, args []string) error {
+	mss := -1
+	if len(args) > 0 && !strings.Contains(args[0], "=") {
+		var err error
+		if mss, err = strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("invalid MSS %q", args[0])
+		}
+		args = args[1:]
+	}
+	tags, err := parseTags(args)
+	if err != nil {
+		return err
+	}
+	catalogue, err := loadCatalogue(dir)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tSTARTED\tTAGS\tPARAMETERS\tDIRECTION\tRESULT")
+	for _, summary := range catalogue {
+		if !matchesTags(summary, tags) {
+			continue
+		}
+		run, err := loadRun(dir, summary.ID)
+		if err != nil {
+			integration.PrettyPrintWarn("Skipping run %s: %s", summary.ID, err)
+			continue
+		}
+		for _, r := range run.Results {
+			if r.Label != label || mss >= 0 && r.Params.MSS != mss {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", run.ID, run.Started.Format(time.RFC3339), formatTags(run.Tags), r.Parameters, r.Direction, formatResult(r.Point))
+		}
+	}
+	return w.Flush()
+}
+
+// formatResult renders the headline number of a data point, percentiles for latency tests and bandwidth otherwise
+func formatResult(p types.Point) string {
+	if p.Latency != nil && p.Latency.Histogram != nil {
+		h := p.Latency.Histogram
+		return fmt.Sprintf("p50 %d us, p99 %d us", h.ValueAtPercentile(50), h.ValueAtPercentile(99))
+	}
+	return p.Bandwidth + " Mbits/sec"
+}
@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"github.com/mrahbar/k8s-nptest/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureStdout returns what the function printed
+func captureStdout(t *testing.T, f func() error) string {
+	fd, err := ioutil.TempFile("", "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+
+	saved := os.Stdout
+	os.Stdout = fd
+	err = f()
+	os.Stdout = saved
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(fd.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	t.Setenv(EnvResultStore, filepath.Join(dir, "runs"))
+	saved := currentRun
+	defer func() { currentRun = saved }()
+
+	started := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	newer := types.Run{RunSummary: types.RunSummary{ID: "20261019-090000", Started: started.Add(time.Hour), Finished: started.Add(2 * time.Hour),
+		Tags: map[string]string{"cni": "cilium"}}, Results: []types.Result{
+		{Label: "3 iperf TCP. Remote VM using Pod IP", Parameters: "mss=1460", Point: types.Point{Params: types.Params{MSS: 1460}, Bandwidth: "9100"}},
+	}}
+	older := types.Run{RunSummary: types.RunSummary{ID: "20261019-080000", Started: started, Finished: started.Add(time.Hour),
+		Tags: map[string]string{"cni": "calico"}}, Results: []types.Result{
+		{Label: "3 iperf TCP. Remote VM using Pod IP", Parameters: "mss=1460", Point: types.Point{Params: types.Params{MSS: 1460}, Bandwidth: "8700"}},
+		{Label: "3 iperf TCP. Remote VM using Pod IP", Parameters: "mss=96", Point: types.Point{Params: types.Params{MSS: 96}, Bandwidth: "900"}},
+	}}
+	// The newer run is stored first and twice, the catalogue is ordered by start and holds every run once
+	for _, run := range []types.Run{newer, newer, older} {
+		currentRun = run.RunSummary
+		storeRun(run.Results)
+	}
+
+	store := ResultStoreDir()
+	catalogue, err := loadCatalogue(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	older.Points, newer.Points = len(older.Results), len(newer.Results)
+	if want := []types.RunSummary{older.RunSummary, newer.RunSummary}; !reflect.DeepEqual(catalogue, want) {
+		t.Errorf("got catalogue %+v, want %+v", catalogue, want)
+	}
+	for _, want := range []types.Run{older, newer} {
+		if run, err := loadRun(store, want.ID); err != nil || !reflect.DeepEqual(*run, want) {
+			t.Errorf("run %s: got %+v, want %+v: %v", want.ID, run, want, err)
+		}
+	}
+	for _, id := range []string{"", "../runs/" + older.ID, "catalogue", "unknown"} {
+		if _, err := loadRun(store, id); err == nil {
+			t.Errorf("loaded run %q", id)
+		}
+	}
+
+	list := captureStdout(t, func() error { return listRuns(store, []string{"cni=calico"}) })
+	if !strings.Contains(list, older.ID) || strings.Contains(list, newer.ID) {
+		t.Errorf("runs tagged cni=calico:\n%s", list)
+	}
+	trend := captureStdout(t, func() error { return showTrend(store, "3 iperf TCP. Remote VM using Pod IP", []string{"1460"}) })
+	if lines := strings.Split(strings.TrimSpace(trend), "\n"); len(lines) != 3 || !strings.Contains(lines[1], "8700") || !strings.Contains(lines[2], "9100") {
+		t.Errorf("trend of MSS 1460:\n%s", trend)
+	}
+
+	if err := deleteRun(store, older.ID); err != nil {
+		t.Fatal(err)
+	}
+	if catalogue, err := loadCatalogue(store); err != nil || !reflect.DeepEqual(catalogue, []types.RunSummary{newer.RunSummary}) {
+		t.Errorf("got catalogue %+v after delete: %v", catalogue, err)
+	}
+	if _, err := loadRun(store, older.ID); !os.IsNotExist(err) {
+		t.Errorf("deleted run still loads: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockStore takes an exclusive lock on the results store, so the orchestrator storing a run and a delete from
+// the command line never rewrite the catalogue at the same time. The lock goes with the process holding it.
+func lockStore(dir string) (unlock func(), err error) {
+	fd, err := os.OpenFile(filepath.Join(dir, storeLockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(fd.Fd()), syscall.LOCK_EX); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return func() { fd.Close() }, nil
+}
@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/types"
+	"strconv"
+)
+
+// expandJobs turns every testcase into one job per element of the cartesian product of its sweep.
+// The first dimension of a sweep is the outermost, so the last dimension varies fastest.
+// Every tuple is scheduled Repetitions times in a row.
+func expandJobs(testcases []*types.Testcase) (rv []*types.Job, err error) {
+	for n, testcase := range testcases {
+		if err = validateFlows(testcase); err != nil {
+			return nil, err
+		}
+
+		tuples := []types.Params{testcase.Params}
+		for _, dimension := range testcase.Sweep {
+			if len(dimension.Values) == 0 {
+				return nil, fmt.Errorf("testcase '%s' sweeps %s without values", testcase.Label, dimension.Name)
+			}
+
+			var expanded []types.Params
+			for _, params := range tuples {
+				for _, value := range dimension.Values {
+					if err = params.Set(dimension.Name, value); err != nil {
+						return nil, fmt.Errorf("testcase '%s': %s", testcase.Label, err)
+					}
+					expanded = append(expanded, params)
+				}
+			}
+			tuples = expanded
+		}
+
+		for _, params := range tuples {
+			for i := 0; i < testcase.Repetitions || i == 0; i++ {
+				job := &types.Job{Testcase: n, Params: params, Assigned: make([]bool, len(testcaseFlows(testcase)))}
+				for _, flow := range testcase.Flows {
+					job.Flows = append(job.Flows, types.FlowResult{SourceNode: flow.SourceNode, DestinationNode: flow.DestinationNode})
+				}
+				rv = append(rv, job)
+			}
+		}
+	}
+	return
+}
+
+// mssRange returns the MSS values from mssMin up to mssMax in steps of mssStepSize
+func mssRange() (rv []string) {
+	for mss := mssMin; mss <= mssMax; mss += mssStepSize {
+		rv = append(rv, strconv.Itoa(mss))
+	}
+	return
+}
+
+// sweepDimensions returns the dimensions of the testcase a point was measured for
+func sweepDimensions(points []types.Point) []types.Dimension {
+	if len(points) == 0 {
+		return nil
+	}
+	return testcases[jobs[points[0].Index].Testcase].Sweep
+}
+
+// isMssSweep reports whether the points belong to a testcase sweeping nothing but the MSS
+func isMssSweep(points []types.Point) bool {
+	dimensions := sweepDimensions(points)
+	return len(dimensions) == 1 && dimensions[0].Name == types.ParamMSS
+}
+
+// isSweep reports whether the points belong to a testcase sweeping anything else than just the MSS
+func isSweep(points []types.Point) bool {
+	return len(sweepDimensions(points)) > 0 && !isMssSweep(points)
+}
+
+// isLatency reports whether the points carry round trip times instead of a bandwidth
+func isLatency(points []types.Point) bool {
+	return len(points) > 0 && points[0].Latency != nil
+}
@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"github.com/mrahbar/k8s-nptest/types"
+	"reflect"
+	"testing"
+)
+
+func TestExpandJobs(t *testing.T) {
+	tests := []struct {
+		name     string
+		testcase types.Testcase
+		want     []types.Params
+	}{
+		{"empty sweep", types.Testcase{Params: types.Params{MSS: mssMax}},
+			[]types.Params{{MSS: mssMax}}},
+		{"one dimension", types.Testcase{Sweep: []types.Dimension{{Name: types.ParamMSS, Values: []string{"96", "160", "224"}}}},
+			[]types.Params{{MSS: 96}, {MSS: 160}, {MSS: 224}}},
+		{"several dimensions, last varies fastest", types.Testcase{Params: types.Params{Duration: 5}, Sweep: []types.Dimension{
+			{Name: types.ParamStreams, Values: []string{"1", "2"}},
+			{Name: types.ParamRate, Values: []string{"100M", "1G"}},
+			{Name: types.ParamDirection, Values: []string{types.DirectionUpload, types.DirectionDownload}},
+		}}, []types.Params{
+			{Duration: 5, Streams: 1, Rate: "100M", Direction: types.DirectionUpload}, {Duration: 5, Streams: 1, Rate: "100M", Direction: types.DirectionDownload},
+			{Duration: 5, Streams: 1, Rate: "1G", Direction: types.DirectionUpload}, {Duration: 5, Streams: 1, Rate: "1G", Direction: types.DirectionDownload},
+			{Duration: 5, Streams: 2, Rate: "100M", Direction: types.DirectionUpload}, {Duration: 5, Streams: 2, Rate: "100M", Direction: types.DirectionDownload},
+			{Duration: 5, Streams: 2, Rate: "1G", Direction: types.DirectionUpload}, {Duration: 5, Streams: 2, Rate: "1G", Direction: types.DirectionDownload},
+		}},
+		{"repetitions", types.Testcase{Repetitions: 3, Sweep: []types.Dimension{{Name: types.ParamStreams, Values: []string{"1", "2"}}}},
+			[]types.Params{{Streams: 1}, {Streams: 1}, {Streams: 1}, {Streams: 2}, {Streams: 2}, {Streams: 2}}},
+	}
+	for _, tt := range tests {
+		testcase := tt.testcase
+		testcase.SourceNode, testcase.DestinationNode, testcase.Label, testcase.Type = "netperf-w1", "netperf-w2", tt.name, iperfTcpTest
+		jobs, err := expandJobs([]*types.Testcase{&testcase})
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		var got []types.Params
+		for _, job := range jobs {
+			if job.Testcase != 0 || len(job.Assigned) != 1 {
+				t.Errorf("%s: job of testcase %d with %d flows", tt.name, job.Testcase, len(job.Assigned))
+			}
+			got = append(got, job.Params)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpandJobsInvalidSweep(t *testing.T) {
+	for _, dimension := range []types.Dimension{
+		{Name: types.ParamMSS},
+		{Name: types.ParamMSS, Values: []string{"large"}},
+		{Name: "unknown", Values: []string{"1"}},
+	} {
+		testcase := &types.Testcase{SourceNode: "netperf-w1", DestinationNode: "netperf-w2", Label: "invalid", Type: iperfTcpTest, Sweep: []types.Dimension{dimension}}
+		if _, err := expandJobs([]*types.Testcase{testcase}); err == nil {
+			t.Errorf("sweep %+v expanded without error", dimension)
+		}
+	}
+}
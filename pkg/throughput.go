@@ -0,0 +1,409 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"github.com/mrahbar/k8s-nptest/integration"
+	"github.com/mrahbar/k8s-nptest/types"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Native throughput engine: a TCP/UDP traffic generator and sink built into the binary.
+//
+// Every TCP connection to the sink starts with a single mode byte. A stream connection ('T')
+// carries payload until the client half-closes it, the sink then answers with the number of
+// bytes it received. A control connection ('U') announces a UDP session id and its number of
+// streams, the sink acknowledges the registered session with a single byte before the client
+// starts sending. The datagrams of the session carry a header with session id, stream index,
+// sequence and send timestamp.
+// Once the client is done sending it writes a single byte on the control connection and the
+// sink answers with the gob encoded per stream counters of the session.
+const (
+	nativeStreamMode  = 'T'
+	nativeControlMode = 'U'
+
+	nativeUdpHeaderSize = 28
+	nativeMaxStreams    = 32 // Upper bound of the streams of a UDP session, the largest of the stream sweep
+	nativeUdpDrainTime  = 250 * time.Millisecond
+	nativeDialTimeout   = 10 * time.Second
+)
+
+type nativeSink struct {
+	sync.Mutex
+	sessions map[uint64][]*nativeUdpCounter
+}
+
+type nativeUdpCounter struct {
+	bytes    int64
+	received int64
+	jitter   float64 // RFC 3550 interarrival jitter in ns
+	transit  int64
+}
+
+// Invoke and indefinitely run the native throughput sink
+func nativeServer(port string) {
+	integration.PrettyPrintInfo("Starting native throughput server on %s", clientData.Worker)
+	sink := &nativeSink{sessions: make(map[uint64][]*nativeUdpCounter)}
+
+	packetConn, err := net.ListenPacket("udp", ":"+port)
+	if err != nil {
+		integration.PrettyPrintErr("Native throughput server failed to listen on udp port %s: %s", port, err)
+		return
+	}
+	go sink.serveUdp(packetConn)
+
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		integration.PrettyPrintErr("Native throughput server failed to listen on tcp port %s: %s", port, err)
+		return
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			integration.PrettyPrintErr("Native throughput server failed to accept connection: %s", err)
+			return
+		}
+		go sink.handle(conn)
+	}
+}
+
+func (s *nativeSink) handle(conn net.Conn) {
+	defer conn.Close()
+
+	mode := make([]byte, 1)
+	if _, err := io.ReadFull(conn, mode); err != nil {
+		return
+	}
+
+	switch mode[0] {
+	case nativeStreamMode:
+		var received uint64
+		buf := make([]byte, nativeBufferSize)
+		for {
+			n, err := conn.Read(buf)
+			received += uint64(n)
+			if err != nil {
+				break
+			}
+		}
+		binary.Write(conn, binary.BigEndian, received)
+
+	case nativeControlMode:
+		var session uint64
+		var streams uint32
+		if err := binary.Read(conn, binary.BigEndian, &session); err != nil {
+			return
+		}
+		if err := binary.Read(conn, binary.BigEndian, &streams); err != nil || streams == 0 || streams > nativeMaxStreams {
+			return
+		}
+		counters := make([]*nativeUdpCounter, streams)
+		for i := range counters {
+			counters[i] = &nativeUdpCounter{}
+		}
+		s.Lock()
+		s.sessions[session] = counters
+		s.Unlock()
+
+		// Datagrams of unknown sessions are dropped, so the client only starts sending once it is registered
+		if _, err := conn.Write([]byte{nativeControlMode}); err != nil {
+			s.Lock()
+			delete(s.sessions, session)
+			s.Unlock()
+			return
+		}
+
+		// Wait for the client to finish sending and give late datagrams a chance to arrive
+		io.ReadFull(conn, mode)
+		time.Sleep(nativeUdpDrainTime)
+
+		s.Lock()
+		delete(s.sessions, session)
+		results := make([]types.StreamResult, len(counters))
+		for i, c := range counters {
+			results[i] = types.StreamResult{Bytes: c.bytes, Received: c.received, Jitter: c.jitter / float64(time.Millisecond)}
+		}
+		s.Unlock()
+		gob.NewEncoder(conn).Encode(results)
+	}
+}
+
+func (s *nativeSink) serveUdp(conn net.PacketConn) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			integration.PrettyPrintErr("Native throughput server failed to read datagram: %s", err)
+			return
+		}
+		if n < nativeUdpHeaderSize {
+			continue
+		}
+		arrival := time.Now().UnixNano()
+		session := binary.BigEndian.Uint64(buf[0:8])
+		stream := binary.BigEndian.Uint32(buf[8:12])
+		sent := int64(binary.BigEndian.Uint64(buf[20:28]))
+
+		s.Lock()
+		counters, ok := s.sessions[session]
+		if ok && int(stream) < len(counters) {
+			c := counters[stream]
+			transit := arrival - sent
+			if c.received > 0 {
+				d := math.Abs(float64(transit - c.transit))
+				c.jitter += (d - c.jitter) / 16
+			}
+			c.transit = transit
+			c.bytes += int64(n)
+			c.received++
+		}
+		s.Unlock()
+	}
+}
+
+// nativeThroughputClient runs the native traffic generator against the sink on serverHost
+func nativeThroughputClient(serverHost, serverPort string, params types.Params, workItemType int) *types.ThroughputResult {
+	streams := parallelStreams
+	if params.Streams > 0 {
+		streams = params.Streams
+	}
+	duration := time.Duration(defaultDuration) * time.Second
+	if params.Duration > 0 {
+		duration = time.Duration(params.Duration) * time.Second
+	}
+	address := net.JoinHostPort(serverHost, serverPort)
+
+	if workItemType == nativeUdpTest {
+		integration.PrettyPrintInfo("Starting native udp client on %s to %s with %d streams", clientData.Worker, serverHost, streams)
+		return nativeUdpClient(address, streams, duration, params)
+	}
+	integration.PrettyPrintInfo("Starting native tcp client on %s to %s with %d streams", clientData.Worker, serverHost, streams)
+	return nativeTcpClient(address, streams, duration, params)
+}
+
+func nativeTcpClient(address string, streams int, duration time.Duration, params types.Params) *types.ThroughputResult {
+	bufferSize := nativeBufferSize
+	if params.BufferSize > 0 {
+		bufferSize = params.BufferSize
+	}
+	dialer := &net.Dialer{Timeout: nativeDialTimeout, Control: mssControl(params.MSS)}
+
+	result := &types.ThroughputResult{Protocol: "tcp", Streams: make([]types.StreamResult, streams)}
+	start := time.Now()
+	deadline := start.Add(duration)
+
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result.Streams[i] = nativeTcpStream(dialer, address, bufferSize, deadline)
+		}(i)
+	}
+	wg.Wait()
+
+	result.Duration = time.Since(start).Seconds()
+	for _, s := range result.Streams {
+		result.Bytes += s.Bytes
+	}
+	result.Mbps = mbps(result.Bytes, result.Duration)
+	return result
+}
+
+func nativeTcpStream(dialer *net.Dialer, address string, bufferSize int, deadline time.Time) (rv types.StreamResult) {
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		rv.Error = err.Error()
+		return
+	}
+	defer conn.Close()
+	start := time.Now()
+
+	buf := make([]byte, bufferSize)
+	buf[0] = nativeStreamMode
+	if _, err = conn.Write(buf[:1]); err != nil {
+		rv.Error = err.Error()
+		return
+	}
+
+	conn.SetWriteDeadline(deadline)
+	for time.Now().Before(deadline) {
+		if _, err = conn.Write(buf); err != nil {
+			break
+		}
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+
+	// The sink reports what was actually received once it has seen the end of the stream
+	var received uint64
+	conn.SetReadDeadline(time.Now().Add(nativeDialTimeout))
+	if err = binary.Read(conn, binary.BigEndian, &received); err != nil {
+		rv.Error = err.Error()
+		return
+	}
+	rv.Bytes = int64(received)
+	rv.Mbps = mbps(rv.Bytes, time.Since(start).Seconds())
+	return
+}
+
+func nativeUdpClient(address string, streams int, duration time.Duration, params types.Params) *types.ThroughputResult {
+	result := &types.ThroughputResult{Protocol: "udp", Streams: make([]types.StreamResult, streams)}
+
+	datagramSize := nativeDatagram
+	if params.DatagramSize > nativeUdpHeaderSize {
+		datagramSize = params.DatagramSize
+	}
+	rate, err := parseRate(params.Rate)
+	if err != nil {
+		result.Streams[0].Error = err.Error()
+		return result
+	}
+
+	control, err := net.DialTimeout("tcp", address, nativeDialTimeout)
+	if err != nil {
+		result.Streams[0].Error = err.Error()
+		return result
+	}
+	defer control.Close()
+
+	session := uint64(rand.New(rand.NewSource(time.Now().UnixNano())).Int63())
+	control.Write([]byte{nativeControlMode})
+	binary.Write(control, binary.BigEndian, session)
+	binary.Write(control, binary.BigEndian, uint32(streams))
+	control.SetReadDeadline(time.Now().Add(nativeDialTimeout))
+	if _, err := io.ReadFull(control, make([]byte, 1)); err != nil {
+		result.Streams[0].Error = fmt.Sprintf("session not acknowledged: %s", err)
+		return result
+	}
+
+	start := time.Now()
+	deadline := start.Add(duration)
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result.Streams[i] = nativeUdpStream(address, session, uint32(i), datagramSize, rate/float64(streams), deadline)
+		}(i)
+	}
+	wg.Wait()
+	result.Duration = time.Since(start).Seconds()
+
+	var received []types.StreamResult
+	control.Write([]byte{0})
+	control.SetReadDeadline(time.Now().Add(nativeDialTimeout))
+	if err := gob.NewDecoder(control).Decode(&received); err != nil {
+		result.Streams[0].Error = err.Error()
+		return result
+	}
+
+	var sent, delivered int64
+	for i := range result.Streams {
+		if i >= len(received) {
+			break
+		}
+		s := &result.Streams[i]
+		s.Bytes, s.Received, s.Jitter = received[i].Bytes, received[i].Received, received[i].Jitter
+		s.Mbps = mbps(s.Bytes, result.Duration)
+		result.Bytes += s.Bytes
+		result.Jitter += s.Jitter / float64(streams)
+		sent += s.Sent
+		delivered += s.Received
+	}
+	result.Mbps = mbps(result.Bytes, result.Duration)
+	if sent > 0 && delivered < sent {
+		result.LossPercent = float64(sent-delivered) * 100 / float64(sent)
+	}
+	return result
+}
+
+// nativeUdpStream sends datagrams until the deadline, paced to the given rate in bits/sec or unlimited if 0
+func nativeUdpStream(address string, session uint64, stream uint32, datagramSize int, rate float64, deadline time.Time) (rv types.StreamResult) {
+	conn, err := net.DialTimeout("udp", address, nativeDialTimeout)
+	if err != nil {
+		rv.Error = err.Error()
+		return
+	}
+	defer conn.Close()
+
+	buf := make([]byte, datagramSize)
+	binary.BigEndian.PutUint64(buf[0:8], session)
+	binary.BigEndian.PutUint32(buf[8:12], stream)
+	packetsPerSecond := rate / float64(8*datagramSize)
+
+	start := time.Now()
+	for now := start; now.Before(deadline); now = time.Now() {
+		if rate > 0 && float64(rv.Sent) >= now.Sub(start).Seconds()*packetsPerSecond {
+			time.Sleep(100 * time.Microsecond)
+			continue
+		}
+		binary.BigEndian.PutUint64(buf[12:20], uint64(rv.Sent))
+		binary.BigEndian.PutUint64(buf[20:28], uint64(now.UnixNano()))
+		// Sending fails transiently when the socket buffer is full, such datagrams are not counted
+		if _, err := conn.Write(buf); err == nil {
+			rv.Sent++
+		}
+	}
+	return
+}
+
+// parseRate converts an iperf style rate like 100M or 1G into bits/sec, 0 meaning unlimited
+func parseRate(rate string) (float64, error) {
+	if len(rate) == 0 {
+		return 0, nil
+	}
+	multiplier := 1.0
+	switch strings.ToUpper(rate[len(rate)-1:]) {
+	case "K":
+		multiplier = 1e3
+	case "M":
+		multiplier = 1e6
+	case "G":
+		multiplier = 1e9
+	}
+	if multiplier > 1 {
+		rate = rate[:len(rate)-1]
+	}
+	value, err := strconv.ParseFloat(rate, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", rate)
+	}
+	return value * multiplier, nil
+}
+
+// formatThroughputResult renders a native throughput result for the raw output file
+func formatThroughputResult(result *types.ThroughputResult) string {
+	rv := fmt.Sprintf("native %s: %d streams, %.2f sec, %d bytes, %.2f Mbits/sec", result.Protocol, len(result.Streams), result.Duration, result.Bytes, result.Mbps)
+	if result.Protocol == "udp" {
+		rv += fmt.Sprintf(", %.3f ms jitter, %g%% loss", result.Jitter, result.LossPercent)
+	}
+	rv += "\n"
+	for i, s := range result.Streams {
+		rv += fmt.Sprintf("  stream %d: %d bytes, %.2f Mbits/sec", i, s.Bytes, s.Mbps)
+		if result.Protocol == "udp" {
+			rv += fmt.Sprintf(", %d/%d datagrams", s.Received, s.Sent)
+		}
+		if len(s.Error) > 0 {
+			rv += ", error: " + s.Error
+		}
+		rv += "\n"
+	}
+	return rv
+}
+
+func mbps(bytes int64, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(bytes) * 8 / seconds / 1e6
+}
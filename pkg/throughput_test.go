@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"github.com/mrahbar/k8s-nptest/types"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startNativeServer runs the native throughput sink on a free loopback port and waits until it accepts connections
+func startNativeServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+
+	go nativeServer(port)
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port)); err == nil {
+			conn.Close()
+			return port
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("native server did not start on port %s", port)
+	return ""
+}
+
+func checkStreams(t *testing.T, result *types.ThroughputResult, streams int) {
+	if len(result.Streams) != streams {
+		t.Fatalf("got %d streams, want %d", len(result.Streams), streams)
+	}
+	var sum int64
+	for i, s := range result.Streams {
+		if len(s.Error) > 0 {
+			t.Fatalf("stream %d failed: %s", i, s.Error)
+		}
+		sum += s.Bytes
+	}
+	if result.Bytes <= 0 {
+		t.Fatalf("no bytes received: %+v", result)
+	}
+	if sum != result.Bytes {
+		t.Errorf("streams sum up to %d bytes, total is %d", sum, result.Bytes)
+	}
+}
+
+func TestNativeThroughputTcp(t *testing.T) {
+	port := startNativeServer(t)
+	result := nativeThroughputClient("127.0.0.1", port, types.Params{Streams: 2, Duration: 1}, nativeTcpTest)
+	checkStreams(t, result, 2)
+	if result.Protocol != "tcp" || result.Mbps <= 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestNativeThroughputUdp(t *testing.T) {
+	port := startNativeServer(t)
+	result := nativeThroughputClient("127.0.0.1", port, types.Params{Streams: 2, Duration: 1, Rate: "20M"}, nativeUdpTest)
+	checkStreams(t, result, 2)
+	if result.Protocol != "udp" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	// Loopback at a modest rate loses nothing, datagrams sent before the session was registered would count as loss
+	if result.LossPercent > 0.5 {
+		t.Errorf("loss of %.2f%% on loopback", result.LossPercent)
+	}
+	for i, s := range result.Streams {
+		if s.Received == 0 || s.Received > s.Sent {
+			t.Errorf("stream %d received %d of %d datagrams", i, s.Received, s.Sent)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package pkg
 
+import "github.com/mrahbar/k8s-nptest/types"
+
 var debug bool
 
 // Worker specific
@@ -8,33 +10,144 @@ const (
 	iperf3Path        = "/usr/bin/iperf3"
 	netperfPath       = "/usr/local/bin/netperf"
 	netperfServerPath = "/usr/local/bin/netserver"
-	parallelStreams   = "8"
+	parallelStreams   = 8
+	defaultDuration   = 10
+	nativeBufferSize  = 128 * 1024
+	nativeDatagram    = 1400
+	latencyMessage    = 64
+	latencyTimeout    = 1            // Seconds a single round trip may take
+	latencyHighest    = 60 * 1000000 // Highest trackable round trip time in microseconds
+	latencyDigits     = 3
+	httpResponseSize  = 1024
+	httpMaxResponse   = 64 * 1024 * 1024
+	httpMaxInFlight   = 1000 // Outstanding requests of a fixed QPS run before requests are dropped
+	grpcStreamMessage = 32 * 1024
+	grpcMaxMessage    = 4 * 1024 * 1024
+	connScaleDefault  = 10000 // Concurrent connections of a connection scale test without sweep
+	dnsTimeout        = 2     // Seconds a single lookup may take
+	dnsServerPort     = "53"
+	fetchKeepalive    = 30  // Seconds a work request blocks at most while the orchestrator has no work
+	clockTicks        = 100 // USER_HZ, the unit of the CPU times in /proc
+
+	preflightServerWait  = 10 // Seconds the own servers get to listen during the pre-flight checks
+	preflightDialTimeout = 5  // Seconds a pre-flight connection may take
 
 	EnvOrchestratorPort  = "orchestratorPort"
 	EnvOrchestratorPodIP = "orchestratorPodIP"
 	EnvWorkerPodIP       = "workerPodIP"
+	EnvWorkerPodIPs      = "workerPodIPs"
 	EnvWorkerName        = "workerName"
+	EnvWorkerServiceName = "workerServiceName"
+	EnvWorkerServiceIP   = "workerServiceIP"
+	EnvWorkerHostNetwork = "workerHostNetwork"
+	EnvWorkerNodeIPs     = "workerNodeIPs"
+	EnvWorkerNodePorts   = "workerNodePorts"
+	EnvWorkerKeepalive   = "workerKeepalive"
 )
 
 // Orchestrator specific
 const (
-	OrchestratorMode  = "orchestrator"
-	outputCaptureFile = "/tmp/output.txt"
-	resultCaptureFile = "/tmp/result.csv"
-	mssMin            = 96
-	mssMax            = 1460
-	mssStepSize       = 64
+	OrchestratorMode    = "orchestrator"
+	resultBaseDir       = "/tmp/results" // Every run writes into a directory of its own below
+	resultCsvName       = "result.csv"
+	resultJsonName      = "result.json"
+	manifestName        = "manifest.json"
+	planName            = "plan.json"
+	logName             = "orchestrator.log"
+	jobOutputDir        = "jobs"
+	latestLink          = "latest"
+	archivePath         = "/archive" // Download of the tar.gz of a finished run on the RPC listener
+	checkpointFile      = "/tmp/checkpoint.json"
+	resultStoreDir      = "/tmp/runs"
+	mssMin              = 96
+	mssMax              = 1460
+	mssStepSize         = 64
+	udpLossThreshold    = 0.1
+	udpUnlimitedRate    = "0"
+	latencyRepetitions  = 3
+	httpFixedQPS        = 1000
+	scheduledStartDelay = 15   // Seconds between handing out the first flow of a job with a scheduled start and the start
+	barrierTimeout      = 120  // Seconds flows wait at a start barrier for the others
+	jobTimeout          = 3600 // Seconds the destination workers sample their CPU at most while waiting for the outputs of a job
+	dnsFqdn             = "kubernetes.default.svc.cluster.local."
+	dnsNonExistent      = "netperf-nonexistent"
 
 	rpcServicePort    = "5202"
 	iperf3ServerPort  = "5201"
 	netperfServerPort = "12865"
+	nativeServerPort  = "5203"
+	latencyServerPort = "5204"
+	httpServerPort    = "5205"
+	grpcServerPort    = "5206"
+
+	iperf3FlowBasePort = 5210 // Further iperf3 servers for concurrent flows to one destination listen above
+	iperf3MaxFlows     = 8
+
+	EnvAddressFamilies     = "addressFamilies"
+	EnvNodePortTests       = "nodePortTests"
+	EnvHostNetworkTests    = "hostNetworkTests"
+	EnvServicePortTests    = "servicePortTests"
+	EnvEgressTarget        = "egressTarget"
+	EnvEgressNetperfTarget = "egressNetperfTarget"
+	EnvCheckpointFile      = "checkpointFile"
+	EnvResultStore         = "resultStore"
+	EnvResultDir           = "resultDir"
+	EnvRunTags             = "runTags"
 
 	csvDataMarker    = "GENERATING CSV OUTPUT"
 	csvEndDataMarker = "END CSV DATA"
 )
 
 const (
-	iperfTcpTest = iota
-	iperfUdpTest = iota
-	netperfTest  = iota
+	iperfTcpTest   = iota
+	iperfUdpTest   = iota
+	netperfTest    = iota
+	nativeTcpTest  = iota
+	nativeUdpTest  = iota
+	latencyTcpTest = iota
+	latencyUdpTest = iota
+	httpTest       = iota
+	grpcUnaryTest  = iota
+	grpcStreamTest = iota
+	connRateTest   = iota
+	connScaleTest  = iota
+	dnsTest        = iota
 )
+
+// Sweeps of the default testcases
+var (
+	mssSweep    = []types.Dimension{{Name: types.ParamMSS, Values: mssRange()}}
+	rateSweep   = []types.Dimension{{Name: types.ParamRate, Values: []string{"100M", "250M", "500M", "1G", "2G", "5G", "10G"}}}
+	streamSweep = []types.Dimension{{Name: types.ParamStreams, Values: []string{"1", "2", "4", "8", "16", "32"}}}
+	connSweep   = []types.Dimension{{Name: types.ParamConnections, Values: []string{"1000", "2000", "5000", "10000", "20000"}}}
+	httpSweep   = []types.Dimension{
+		{Name: types.ParamHttpVersion, Values: []string{types.Http1, types.Http2}},
+		{Name: types.ParamKeepAlive, Values: []string{types.KeepAliveOn, types.KeepAliveOff}},
+	}
+)
+
+// Flows of the default multi-flow testcases
+var (
+	incastFlows = []types.Flow{{SourceNode: "netperf-w1", DestinationNode: "netperf-w2"}, {SourceNode: "netperf-w3", DestinationNode: "netperf-w2"}}
+	fanOutFlows = []types.Flow{{SourceNode: "netperf-w1", DestinationNode: "netperf-w2"}, {SourceNode: "netperf-w1", DestinationNode: "netperf-w3"}}
+)
+
+// Titles of the X-axis of a sweep in the csv output
+var sweepTitles = map[string]string{
+	types.ParamMSS:          "MSS",
+	types.ParamDatagramSize: "Datagram size",
+	types.ParamStreams:      "Parallel streams",
+	types.ParamCongestion:   "Congestion control",
+	types.ParamRate:         "Offered rate",
+	types.ParamDuration:     "Duration (s)",
+	types.ParamDirection:    "Direction",
+	types.ParamBufferSize:   "Buffer size",
+	types.ParamResponseSize: "Response size",
+	types.ParamQPS:          "Requests/sec",
+	types.ParamKeepAlive:    "Keep-alive",
+	types.ParamHttpVersion:  "HTTP version",
+	types.ParamConnections:  "Connections",
+	types.ParamDnsServer:    "Nameserver",
+	types.ParamDnsNames:     "Names",
+	types.ParamFamily:       "Address family",
+}
@@ -4,18 +4,22 @@ import (
 	"bytes"
 	"github.com/mrahbar/k8s-nptest/integration"
 	"github.com/mrahbar/k8s-nptest/types"
+	"net"
 	"net/rpc"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 var orchestrator types.Orchestrator
 var clientData types.Worker
+var keepalive = fetchKeepalive
+var serversStarted bool
 
-//Visit sites for iperf and netperf args documentation
+// Visit sites for iperf and netperf args documentation
 // http://software.es.net/iperf/invoking.html
 // http://www.cs.kent.edu/~farrell/dist/ref/Netperf.html
 func Work(d bool) {
@@ -23,12 +27,95 @@ func Work(d bool) {
 
 	orchestrator.Port = os.Getenv(EnvOrchestratorPort)
 	orchestrator.Address = os.Getenv(EnvOrchestratorPodIP)
+	if len(orchestrator.Address) == 0 || len(orchestrator.Port) == 0 {
+		integration.PrettyPrintErr("Set %s and %s to the address and port of the orchestrator", EnvOrchestratorPodIP, EnvOrchestratorPort)
+		os.Exit(1)
+	}
+	if net.ParseIP(orchestrator.Address) == nil {
+		if _, err := net.LookupHost(orchestrator.Address); err != nil {
+			integration.PrettyPrintWarn("%s %q is neither an IP nor resolvable: %s", EnvOrchestratorPodIP, orchestrator.Address, err)
+		}
+	}
 	clientData.IP = os.Getenv(EnvWorkerPodIP)
+	clientData.IPs = splitAddresses(os.Getenv(EnvWorkerPodIPs))
+	if len(clientData.IPs) == 0 && len(clientData.IP) > 0 {
+		clientData.IPs = []string{clientData.IP}
+	}
+	if len(clientData.IP) == 0 && len(clientData.IPs) > 0 {
+		clientData.IP = clientData.IPs[0]
+	}
 	clientData.Worker = os.Getenv(EnvWorkerName)
+	clientData.ServiceName = os.Getenv(EnvWorkerServiceName)
+	if len(clientData.ServiceName) == 0 {
+		clientData.ServiceName = clientData.Worker
+	}
+	clientData.ServiceIPs = resolveServiceIPs(clientData.ServiceName)
+	clientData.HostNetwork, _ = strconv.ParseBool(os.Getenv(EnvWorkerHostNetwork))
+	clientData.NodeIPs = splitAddresses(os.Getenv(EnvWorkerNodeIPs))
+	if len(clientData.NodeIPs) == 0 && clientData.HostNetwork {
+		clientData.NodeIPs = clientData.IPs
+	}
+	clientData.NodePorts = parseNodePorts(os.Getenv(EnvWorkerNodePorts))
+	clientData.Fingerprint = collectFingerprint(clientData.IP)
+	if value := os.Getenv(EnvWorkerKeepalive); len(value) > 0 {
+		if k, err := strconv.Atoi(value); err == nil && k > 0 {
+			keepalive = k
+		} else {
+			integration.PrettyPrintWarn("Ignoring invalid keepalive %q, using %d seconds", value, fetchKeepalive)
+		}
+	}
 
 	startWork()
 }
 
+// resolveServiceIPs looks up the ClusterIPs of the Service fronting this worker. Explicitly configured
+// addresses win over the environment variables Kubernetes injects for every Service, which only carry
+// the primary ClusterIP, so the addresses of all families found in DNS are added to those.
+func resolveServiceIPs(service string) []string {
+	if ips := splitAddresses(os.Getenv(EnvWorkerServiceIP)); len(ips) > 0 {
+		return ips
+	}
+	var rv []string
+	env := strings.ToUpper(strings.Replace(service, "-", "_", -1)) + "_SERVICE_HOST"
+	if ip := os.Getenv(env); len(ip) > 0 {
+		rv = append(rv, ip)
+	}
+	addresses, err := net.LookupHost(service)
+	if err != nil && len(rv) == 0 {
+		integration.PrettyPrintWarn("Failed to resolve ClusterIP of Service %s: %s", service, err)
+	}
+	for _, address := range addresses {
+		if !containsString(rv, address) {
+			rv = append(rv, address)
+		}
+	}
+	return rv
+}
+
+// parseNodePorts parses a comma separated list of port:nodePort pairs, e.g. "5201:30201,5203:30203"
+func parseNodePorts(value string) map[string]string {
+	rv := make(map[string]string)
+	for _, pair := range splitAddresses(value) {
+		ports := strings.Split(pair, ":")
+		if len(ports) != 2 {
+			integration.PrettyPrintWarn("Ignoring invalid NodePort mapping %q, expected port:nodePort", pair)
+			continue
+		}
+		rv[ports[0]] = ports[1]
+	}
+	return rv
+}
+
+// splitAddresses splits a comma separated list of addresses as set by the downward API for status.podIPs
+func splitAddresses(value string) (rv []string) {
+	for _, address := range strings.Split(value, ",") {
+		if address = strings.TrimSpace(address); len(address) > 0 {
+			rv = append(rv, address)
+		}
+	}
+	return
+}
+
 // Entry point to the worker infinite loop
 func startWork() {
 	for true {
@@ -39,7 +126,7 @@ func startWork() {
 		timeout = 5
 		for true {
 			integration.PrettyPrintInfo("Attempting to connect to orchestrator at %s", orchestrator.Address)
-			client, err = rpc.DialHTTP("tcp", orchestrator.Address+":"+orchestrator.Port)
+			client, err = rpc.DialHTTP("tcp", net.JoinHostPort(orchestrator.Address, orchestrator.Port))
 			if err == nil {
 				integration.PrettyPrintOk("Connected successfully to orchestrator")
 				break
@@ -48,12 +135,38 @@ func startWork() {
 			time.Sleep(timeout * time.Second)
 		}
 
+		var registration types.WorkItem
+		if err := client.Call("NetPerfRpc.RegisterClient", clientData, &registration); err != nil {
+			integration.PrettyPrintErr("Error attempting RPC call: %s", err)
+			client.Close()
+			time.Sleep(timeout * time.Second)
+			continue
+		}
+		// A restarted orchestrator asks again, the servers keep running across reconnects
+		if registration.IsServerItem && !serversStarted {
+			integration.PrettyPrintInfo("Orchestrator requests worker run iperf, netperf, native, latency, http and grpc server")
+			go iperfServer(iperf3ServerPort)
+			for slot := 1; slot < iperf3MaxFlows; slot++ {
+				go iperfServer(strconv.Itoa(iperf3FlowBasePort + slot))
+			}
+			go netperfServer(netperfServerPort)
+			go nativeServer(nativeServerPort)
+			go latencyServer(latencyServerPort)
+			go httpServer(httpServerPort)
+			go grpcServer(grpcServerPort)
+			serversStarted = true
+			time.Sleep(1 * time.Second)
+		}
+
+		// FetchWork blocks on the orchestrator until there is work, an idle reply only means the keepalive passed
+		request := types.WorkRequest{Worker: clientData.Worker, Keepalive: keepalive}
 		for true {
 			var workItem types.WorkItem
 
-			if err := client.Call("NetPerfRpc.RegisterClient", clientData, &workItem); err != nil {
+			if err := client.Call("NetPerfRpc.FetchWork", request, &workItem); err != nil {
 				// RPC server has probably gone away - attempt to reconnect
 				integration.PrettyPrintErr("Error attempting RPC call: %s", err)
+				client.Close()
 				break
 			}
 
@@ -62,17 +175,19 @@ func startWork() {
 				if debug {
 					integration.PrettyPrintInfo("Orchestrator requests worker run idle")
 				}
-				time.Sleep(5 * time.Second)
 				continue
 
-			case workItem.IsServerItem == true:
-				integration.PrettyPrintInfo("Orchestrator requests worker run iperf and netperf server")
-				go iperfServer(iperf3ServerPort)
-				go netperfServer(netperfServerPort)
-				time.Sleep(1 * time.Second)
+			case workItem.IsPreflightItem == true:
+				integration.PrettyPrintInfo("Orchestrator requests worker run pre-flight checks")
+				runPreflight(client, workItem.PreflightItem)
 
-			case workItem.IsClientItem == true:
-				integration.PrettyPrintInfo("Orchestrator requests worker run as client: %+v", workItem.ClientItem)
+			case workItem.IsClientItem == true || workItem.IsMonitorItem == true:
+				if workItem.IsClientItem {
+					integration.PrettyPrintInfo("Orchestrator requests worker run as client: %+v", workItem.ClientItem)
+				}
+				if workItem.IsMonitorItem {
+					integration.PrettyPrintInfo("Orchestrator requests worker sample its CPU for job %d", workItem.MonitorItem.Job)
+				}
 				handleClientWorkItem(client, &workItem)
 			}
 		}
@@ -80,19 +195,92 @@ func startWork() {
 }
 
 func handleClientWorkItem(client *rpc.Client, workItem *types.WorkItem) {
-	var reply int
+	var wg sync.WaitGroup
+	if workItem.IsMonitorItem {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			monitorJob(client, workItem.MonitorItem)
+		}()
+	}
+
+	// The flows of a multi-flow job run concurrently, every one reports its own output
+	if workItem.IsClientItem {
+		var sampler jobSampler
+		var clients sync.WaitGroup
+		for _, item := range append([]types.IperfClientWorkItem{workItem.ClientItem}, workItem.ClientItems...) {
+			clients.Add(1)
+			go func(item types.IperfClientWorkItem) {
+				defer clients.Done()
+				runClientItem(client, item, &sampler)
+			}(item)
+		}
+		clients.Wait()
+		sampler.report(client, workItem.ClientItem.Job, types.RoleClient)
+	}
+	wg.Wait()
+
+	// Client COOLDOWN period before asking for next work item to replenish burst allowance polices etc
+	time.Sleep(10 * time.Second)
+}
+
+// runClientItem runs a single client item and reports its output to the orchestrator
+func runClientItem(client *rpc.Client, item types.IperfClientWorkItem, sampler *jobSampler) {
+	waitForStart(client, item)
+	sampler.start()
+	output := types.WorkerOutput{Worker: clientData.Worker, Type: item.Type, Job: item.Job, Flow: item.Flow, StartedAt: time.Now()}
 	switch {
-	case workItem.ClientItem.Type == iperfTcpTest || workItem.ClientItem.Type == iperfUdpTest:
+	case item.Type == iperfTcpTest || item.Type == iperfUdpTest:
 		integration.PrettyPrintInfo("Orchestrator requests worker run item Type: iperfTest")
-		outputString := iperfClient(workItem.ClientItem.Host, workItem.ClientItem.Port, workItem.ClientItem.MSS, workItem.ClientItem.Type)
-		client.Call("NetPerfRpc.ReceiveOutput", types.WorkerOutput{Output: outputString, Worker: clientData.Worker, Type: workItem.ClientItem.Type}, &reply)
-	case workItem.ClientItem.Type == netperfTest:
+		output.Output = iperfClient(item.Host, item.Port, item.Params, item.Type)
+	case item.Type == netperfTest:
 		integration.PrettyPrintInfo("Orchestrator requests worker run item Type: netperfTest")
-		outputString := netperfClient(workItem.ClientItem.Host, workItem.ClientItem.Port)
-		client.Call("NetPerfRpc.ReceiveOutput", types.WorkerOutput{Output: outputString, Worker: clientData.Worker, Type: workItem.ClientItem.Type}, &reply)
+		output.Output = netperfClient(item.Host, item.Port, item.Params)
+	case item.Type == nativeTcpTest || item.Type == nativeUdpTest:
+		integration.PrettyPrintInfo("Orchestrator requests worker run item Type: nativeTest")
+		result := nativeThroughputClient(item.Host, item.Port, item.Params, item.Type)
+		output.Output = formatThroughputResult(result)
+		output.Throughput = result
+	case item.Type == latencyTcpTest || item.Type == latencyUdpTest:
+		integration.PrettyPrintInfo("Orchestrator requests worker run item Type: latencyTest")
+		result := latencyClient(item.Host, item.Port, item.Params, item.Type)
+		output.Output = formatLatencyResult(result)
+		output.Latency = result
+	case item.Type == httpTest:
+		integration.PrettyPrintInfo("Orchestrator requests worker run item Type: httpTest")
+		result := httpClient(item.Host, item.Port, item.Params)
+		output.Output = formatLatencyResult(result)
+		output.Latency = result
+	case item.Type == grpcUnaryTest:
+		integration.PrettyPrintInfo("Orchestrator requests worker run item Type: grpcUnaryTest")
+		result := grpcUnaryClient(item.Host, item.Port, item.Params)
+		output.Output = formatLatencyResult(result)
+		output.Latency = result
+	case item.Type == grpcStreamTest:
+		integration.PrettyPrintInfo("Orchestrator requests worker run item Type: grpcStreamTest")
+		result := grpcStreamClient(item.Host, item.Port, item.Params)
+		output.Output = formatThroughputResult(result)
+		output.Throughput = result
+	case item.Type == connRateTest:
+		integration.PrettyPrintInfo("Orchestrator requests worker run item Type: connRateTest")
+		result := connRateClient(item.Host, item.Port, item.Params)
+		output.Output = formatLatencyResult(result)
+		output.Latency = result
+	case item.Type == connScaleTest:
+		integration.PrettyPrintInfo("Orchestrator requests worker run item Type: connScaleTest")
+		result := connScaleClient(item.Host, item.Port, item.Params)
+		output.Output = formatLatencyResult(result)
+		output.Latency = result
+	case item.Type == dnsTest:
+		integration.PrettyPrintInfo("Orchestrator requests worker run item Type: dnsTest")
+		result, dns := dnsClient(item.Host, item.Params)
+		output.Output = formatLatencyResult(result) + formatDnsResult(dns)
+		output.Latency = result
+		output.Dns = dns
 	}
-	// Client COOLDOWN period before asking for next work item to replenish burst allowance polices etc
-	time.Sleep(10 * time.Second)
+
+	var reply int
+	client.Call("NetPerfRpc.ReceiveOutput", output, &reply)
 }
 
 // Invoke and indefinitely run an iperf server
@@ -122,18 +310,56 @@ func netperfServer(port string) {
 }
 
 // Invoke and run an iperf client and return the output if successful.
-func iperfClient(serverHost, serverPort string, mss int, workItemType int) (rv string) {
+func iperfClient(serverHost, serverPort string, params types.Params, workItemType int) (rv string) {
+	duration := defaultDuration
+	if params.Duration > 0 {
+		duration = params.Duration
+	}
+	// Verbose output adds the CPU utilization of both sides to the summary
+	args := []string{"-c", serverHost, "-p", serverPort, "-i", "30", "-t", strconv.Itoa(duration), "-f", "m", "-V"}
+	if len(params.Family) > 0 {
+		args = append(args, "-"+params.Family)
+	}
+	switch params.Direction {
+	case types.DirectionDownload:
+		args = append(args, "-R")
+	case types.DirectionBidir:
+		args = append(args, "--bidir")
+	}
+
 	switch {
 	case workItemType == iperfTcpTest:
-		integration.PrettyPrintInfo("Starting iperf tcp client on %s to %s", clientData.Worker, serverHost)
-		output, success := cmdExec(iperf3Path, []string{"-c", serverHost, "-p", serverPort, "-N", "-i", "30", "-t", "10", "-f", "m", "-w", "512M", "-Z", "-P", parallelStreams, "-M", strconv.Itoa(mss)})
+		streams := parallelStreams
+		if params.Streams > 0 {
+			streams = params.Streams
+		}
+		args = append(args, "-N", "-w", "512M", "-Z", "-P", strconv.Itoa(streams))
+		if params.MSS > 0 {
+			args = append(args, "-M", strconv.Itoa(params.MSS))
+		}
+		if len(params.Congestion) > 0 {
+			args = append(args, "-C", params.Congestion)
+		}
+		integration.PrettyPrintInfo("Starting iperf tcp client on %s to %s with %d streams", clientData.Worker, serverHost, streams)
+		output, success := cmdExec(iperf3Path, args)
 		if success {
 			rv = output
 		}
 
 	case workItemType == iperfUdpTest:
-		integration.PrettyPrintInfo("Starting iperf udp client on %s to %s", clientData.Worker, serverHost)
-		output, success := cmdExec(iperf3Path, []string{"-c", serverHost, "-p", serverPort, "-i", "30", "-t", "10", "-f", "m", "-b", "0", "-u"})
+		rate := udpUnlimitedRate
+		if len(params.Rate) > 0 {
+			rate = params.Rate
+		}
+		args = append(args, "-b", rate, "-u")
+		if params.Streams > 0 {
+			args = append(args, "-P", strconv.Itoa(params.Streams))
+		}
+		if params.DatagramSize > 0 {
+			args = append(args, "-l", strconv.Itoa(params.DatagramSize))
+		}
+		integration.PrettyPrintInfo("Starting iperf udp client on %s to %s at %s", clientData.Worker, serverHost, rate)
+		output, success := cmdExec(iperf3Path, args)
 		if success {
 			rv = output
 		}
@@ -142,10 +368,17 @@ func iperfClient(serverHost, serverPort string, mss int, workItemType int) (rv s
 }
 
 // Invoke and run a netperf client and return the output if successful.
-func netperfClient(serverHost, serverPort string) (rv string) {
+func netperfClient(serverHost, serverPort string, params types.Params) (rv string) {
 	//measures measure bulk tcp data transfer performance
 	integration.PrettyPrintInfo("Starting netperf client on %s to %s", clientData.Worker, serverHost)
-	output, success := cmdExec(netperfPath, []string{"-H", serverHost, "-p", serverPort})
+	args := []string{"-H", serverHost, "-p", serverPort}
+	if len(params.Family) > 0 {
+		args = append(args, "-"+params.Family)
+	}
+	if params.Duration > 0 {
+		args = append(args, "-l", strconv.Itoa(params.Duration))
+	}
+	output, success := cmdExec(netperfPath, args)
 	if success {
 		integration.PrettyPrintInfo(output)
 		rv = output
@@ -0,0 +1,11 @@
+package types
+
+// Checkpoint is the progress of a run the orchestrator persists after every job, workers are not part of it
+// as they register again once they reconnected
+type Checkpoint struct {
+	Run           RunSummary // Identity of the run, a resumed run is stored under the same ID
+	Labels        []string   // Labels of the testcases, the checkpoint of another schedule is not resumed
+	Jobs          []*Job
+	DataPoints    map[string][]Point
+	DataPointKeys []string
+}
@@ -0,0 +1,34 @@
+package types
+
+// InterfaceCounters are the packet counters of a single network interface from /proc/net/dev
+type InterfaceCounters struct {
+	RxPackets int64
+	TxPackets int64
+	RxErrors  int64
+	TxErrors  int64
+	RxDrops   int64
+	TxDrops   int64
+}
+
+// StackCounters are the deltas of the interface and network stack counters of a worker while a job ran.
+// Interface and protocol counters are the ones of the network namespace of the worker, softirqs the ones of the node.
+type StackCounters struct {
+	Worker     string
+	Role       string                       // One of the Role constants
+	Interfaces map[string]InterfaceCounters // Per interface except loopback
+	Snmp       map[string]int64             // Changed counters of /proc/net/snmp and /proc/net/netstat, e.g. Tcp.RetransSegs
+	SoftIrqs   map[string]int64             // Softirqs of all CPUs per type, e.g. NET_RX
+}
+
+// Total returns the sum of the counters of all interfaces
+func (c StackCounters) Total() (rv InterfaceCounters) {
+	for _, i := range c.Interfaces {
+		rv.RxPackets += i.RxPackets
+		rv.TxPackets += i.TxPackets
+		rv.RxErrors += i.RxErrors
+		rv.TxErrors += i.TxErrors
+		rv.RxDrops += i.RxDrops
+		rv.TxDrops += i.TxDrops
+	}
+	return
+}
@@ -0,0 +1,42 @@
+package types
+
+// Roles of the worker a CPU usage was sampled on
+const (
+	RoleClient = "client"
+	RoleServer = "server"
+)
+
+// CpuUsage is the CPU a worker node spent while a job ran, sampled from /proc/stat and /proc/[pid]/stat
+type CpuUsage struct {
+	Worker    string
+	Role      string             // One of the Role constants
+	Duration  float64            // Seconds between both samples
+	Cores     int                // CPUs of the node
+	Busy      float64            // Percent of all CPUs, 100 if every core was busy during the whole job
+	User      float64            // Percent of all CPUs
+	System    float64            // Percent of all CPUs
+	SoftIrq   float64            // Percent of all CPUs, most of the packet processing runs in softirq
+	Processes map[string]float64 // Percent of a single core per command, processes waited for count towards their parent
+}
+
+// BusyCores returns the number of cores the job kept busy
+func (u CpuUsage) BusyCores() float64 {
+	return u.Busy * float64(u.Cores) / 100
+}
+
+// UsageReport carries the CPU usage and the counter deltas of a worker for a job back to the orchestrator
+type UsageReport struct {
+	Job      int
+	Cpu      CpuUsage
+	Counters StackCounters
+}
+
+// IperfCpu is the CPU utilization iperf3 reports itself, named after cpu_utilization_percent of its JSON output
+type IperfCpu struct {
+	HostTotal    float64
+	HostUser     float64
+	HostSystem   float64
+	RemoteTotal  float64
+	RemoteUser   float64
+	RemoteSystem float64
+}
@@ -1,7 +1,47 @@
 package types
 
+import "time"
+
 type Point struct {
-	Mss       int
-	Bandwidth string
-	Index     int
+	Params           Params // Full parameter tuple the point was measured with
+	Direction        string // Direction of the measured traffic, upload or download
+	Bandwidth        string
+	Index            int
+	Address          string          // Host the client connected to, Pod IP, ClusterIP or DNS name
+	Jitter           string          // receiver jitter in ms of a UDP test
+	Loss             string          // datagram loss in percent of a UDP test
+	StreamBandwidths []string        // Mbits/sec of every single parallel stream
+	Latency          *LatencyResult  // Round trip times of a latency test
+	Dns              *DnsResult      // Lookup outcomes of a DNS test
+	Flows            []FlowResult    // Every single flow of a multi-flow test, Bandwidth is their aggregate
+	Fairness         string          // Jain's fairness index of the flows of a multi-flow test
+	StartSkew        string          // Milliseconds between the first and the last flow start of a multi-flow test
+	Cpu              []CpuUsage      // CPU usage of the client and server workers of the job
+	IperfCpu         *IperfCpu       // CPU utilization reported by iperf3
+	Counters         []StackCounters // Interface and network stack counter deltas of the client and server workers of the job
+}
+
+// FlowResult is the bandwidth of a single flow of a multi-flow test
+type FlowResult struct {
+	SourceNode      string
+	DestinationNode string
+	Address         string
+	Bandwidth       string
+	StartedAt       time.Time // Wall clock time the client of the flow actually started
+	Reported        bool
+}
+
+// Result is a single data point together with the testcase it was measured for
+type Result struct {
+	Label           string
+	SourceNode      string
+	DestinationNode string
+	ClusterIP       bool
+	NodePort        bool
+	Egress          bool // The destination is a server outside the cluster
+	Type            int
+	Family          string                 // Address family of the address the client connected to, empty for DNS names
+	Environments    map[string]Fingerprint // Fingerprints of the workers of the testcase by worker name
+	Parameters      string                 // Swept parameters of the point, e.g. "mss=1460 streams=8"
+	Point
 }
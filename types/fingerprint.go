@@ -0,0 +1,14 @@
+package types
+
+// Fingerprint describes the environment a worker measured in, reported once at registration
+type Fingerprint struct {
+	Kernel    string
+	CpuModel  string
+	Cpus      int               // CPUs of the node
+	Interface string            // Interface carrying the primary pod IP
+	Driver    string            // Driver of that interface, virtual for veth and other software devices
+	MTU       int               // MTU of that interface
+	Sysctls   map[string]string // Relevant sysctls as seen by the worker, missing ones are left out
+	Iperf3    string            // Version line of iperf3
+	Netperf   string            // Version line of netperf
+}
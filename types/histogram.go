@@ -0,0 +1,221 @@
+package types
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Histogram is a high dynamic range histogram of latencies in microseconds.
+// Values are tracked with a fixed number of significant decimal digits, the memory
+// footprint only depends on the trackable range and the precision, not on the number
+// of recorded values. Histograms of equal configuration can be merged without loss.
+type Histogram struct {
+	HighestTrackable  int64 // Highest value which can be recorded, larger values are clamped
+	SignificantDigits int   // Decimal precision of the recorded values
+	Counts            []int64
+	TotalCount        int64
+	Min               int64
+	Max               int64
+	Sum               int64
+}
+
+// NewHistogram creates a histogram tracking values from 1 to highest with the given precision (1-5 digits)
+func NewHistogram(highest int64, digits int) *Histogram {
+	h := &Histogram{HighestTrackable: highest, SignificantDigits: digits, Min: math.MaxInt64}
+	h.Counts = make([]int64, h.countsIndex(highest)+1)
+	return h
+}
+
+// subBucketHalfCountMagnitude is the power of two of half the linear sub buckets needed for the precision
+func (h *Histogram) subBucketHalfCountMagnitude() uint {
+	largest := 2 * math.Pow10(h.SignificantDigits)
+	magnitude := uint(math.Ceil(math.Log2(largest)))
+	if magnitude < 1 {
+		magnitude = 1
+	}
+	return magnitude - 1
+}
+
+func (h *Histogram) countsIndex(value int64) int {
+	halfMagnitude := h.subBucketHalfCountMagnitude()
+	subBucketMask := uint64(1)<<(halfMagnitude+1) - 1
+	bucketIndex := 64 - bits.LeadingZeros64(uint64(value)|subBucketMask) - int(halfMagnitude+1)
+	subBucketIndex := int(uint64(value) >> uint(bucketIndex))
+	return (bucketIndex+1)<<halfMagnitude + subBucketIndex - 1<<halfMagnitude
+}
+
+func (h *Histogram) valueFromIndex(index int) int64 {
+	halfMagnitude := h.subBucketHalfCountMagnitude()
+	halfCount := 1 << halfMagnitude
+	bucketIndex := index>>halfMagnitude - 1
+	subBucketIndex := index&(halfCount-1) + halfCount
+	if bucketIndex < 0 {
+		subBucketIndex -= halfCount
+		bucketIndex = 0
+	}
+	return int64(subBucketIndex) << uint(bucketIndex)
+}
+
+// highestEquivalentValue returns the largest value which is counted in the same bucket as the value at index
+func (h *Histogram) highestEquivalentValue(index int) int64 {
+	if index+1 < len(h.Counts) {
+		return h.valueFromIndex(index+1) - 1
+	}
+	return h.HighestTrackable
+}
+
+// RecordValue counts a single value, values outside of the trackable range are clamped
+func (h *Histogram) RecordValue(value int64) {
+	if value < 0 {
+		value = 0
+	}
+	if value > h.HighestTrackable {
+		value = h.HighestTrackable
+	}
+	h.Counts[h.countsIndex(value)]++
+	h.TotalCount++
+	h.Sum += value
+	if value < h.Min {
+		h.Min = value
+	}
+	if value > h.Max {
+		h.Max = value
+	}
+}
+
+// Merge adds all values of other to the histogram
+func (h *Histogram) Merge(other *Histogram) error {
+	if other == nil || other.TotalCount == 0 {
+		return nil
+	}
+	if other.HighestTrackable != h.HighestTrackable || other.SignificantDigits != h.SignificantDigits {
+		return fmt.Errorf("cannot merge histogram of range %d/%d digits into %d/%d digits",
+			other.HighestTrackable, other.SignificantDigits, h.HighestTrackable, h.SignificantDigits)
+	}
+	for i, c := range other.Counts {
+		h.Counts[i] += c
+	}
+	h.TotalCount += other.TotalCount
+	h.Sum += other.Sum
+	if other.Min < h.Min {
+		h.Min = other.Min
+	}
+	if other.Max > h.Max {
+		h.Max = other.Max
+	}
+	return nil
+}
+
+// ValueAtPercentile returns the value below which the given percentage (0-100) of all values fall
+func (h *Histogram) ValueAtPercentile(percentile float64) int64 {
+	if h.TotalCount == 0 {
+		return 0
+	}
+	if percentile >= 100 {
+		return h.Max
+	}
+	target := int64(math.Ceil(percentile / 100 * float64(h.TotalCount)))
+	if target < 1 {
+		target = 1
+	}
+	var total int64
+	for i, c := range h.Counts {
+		total += c
+		if total >= target {
+			value := h.highestEquivalentValue(i)
+			if value > h.Max {
+				return h.Max
+			}
+			return value
+		}
+	}
+	return h.Max
+}
+
+// Mean returns the exact mean of all recorded values
+func (h *Histogram) Mean() float64 {
+	if h.TotalCount == 0 {
+		return 0
+	}
+	return float64(h.Sum) / float64(h.TotalCount)
+}
+
+// sparseHistogram is the encoded form of a histogram. Only the buckets with a count are kept, the dense
+// counts of a latency histogram are tens of thousands of mostly empty buckets. Counts is only read, from
+// documents written before the sparse encoding.
+type sparseHistogram struct {
+	HighestTrackable  int64
+	SignificantDigits int
+	Buckets           [][2]int64 // Index and count of every bucket with a count
+	Counts            []int64    `json:",omitempty"`
+	TotalCount        int64
+	Min               int64
+	Max               int64
+	Sum               int64
+}
+
+func (h *Histogram) sparse() sparseHistogram {
+	rv := sparseHistogram{HighestTrackable: h.HighestTrackable, SignificantDigits: h.SignificantDigits,
+		Buckets: [][2]int64{}, TotalCount: h.TotalCount, Min: h.Min, Max: h.Max, Sum: h.Sum}
+	for i, c := range h.Counts {
+		if c != 0 {
+			rv.Buckets = append(rv.Buckets, [2]int64{int64(i), c})
+		}
+	}
+	return rv
+}
+
+func (h *Histogram) fromSparse(s sparseHistogram) error {
+	if s.HighestTrackable < 1 || s.SignificantDigits < 1 || s.SignificantDigits > 5 {
+		return fmt.Errorf("invalid histogram of range %d/%d digits", s.HighestTrackable, s.SignificantDigits)
+	}
+	*h = *NewHistogram(s.HighestTrackable, s.SignificantDigits)
+	h.TotalCount, h.Min, h.Max, h.Sum = s.TotalCount, s.Min, s.Max, s.Sum
+	if len(s.Counts) > 0 {
+		if len(s.Counts) != len(h.Counts) {
+			return fmt.Errorf("histogram of range %d/%d digits has %d instead of %d buckets", s.HighestTrackable, s.SignificantDigits, len(s.Counts), len(h.Counts))
+		}
+		copy(h.Counts, s.Counts)
+	}
+	for _, b := range s.Buckets {
+		if b[0] < 0 || b[0] >= int64(len(h.Counts)) {
+			return fmt.Errorf("histogram bucket %d out of range", b[0])
+		}
+		h.Counts[b[0]] += b[1]
+	}
+	return nil
+}
+
+// MarshalJSON encodes the histogram with its non-empty buckets only
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.sparse())
+}
+
+// UnmarshalJSON decodes a sparse histogram, or a dense one of an older document
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	var s sparseHistogram
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return h.fromSparse(s)
+}
+
+// GobEncode encodes the histogram with its non-empty buckets only, for the RPCs of the workers
+func (h *Histogram) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(h.sparse())
+	return buf.Bytes(), err
+}
+
+// GobDecode decodes a histogram encoded by GobEncode
+func (h *Histogram) GobDecode(data []byte) error {
+	var s sparseHistogram
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return err
+	}
+	return h.fromSparse(s)
+}
@@ -0,0 +1,157 @@
+package types
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const (
+	testHighest = 60 * 1000000
+	testDigits  = 3
+)
+
+// sampleHistogram records values spread over the whole trackable range and returns them sorted
+func sampleHistogram(n int) (*Histogram, []int64) {
+	r := rand.New(rand.NewSource(1))
+	h := NewHistogram(testHighest, testDigits)
+	values := make([]int64, n)
+	for i := range values {
+		values[i] = int64(math.Exp(r.Float64() * math.Log(testHighest)))
+		h.RecordValue(values[i])
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return h, values
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	h, values := sampleHistogram(100000)
+	for _, percentile := range []float64{0.1, 1, 10, 25, 50, 75, 90, 99, 99.9, 99.99, 100} {
+		target := int(math.Ceil(percentile / 100 * float64(len(values))))
+		exact := values[target-1]
+		got := h.ValueAtPercentile(percentile)
+		// The value reported is the highest of the bucket, which spans less than 1/10^digits of it
+		if got < exact || float64(got) > float64(exact)*(1+math.Pow10(-testDigits))+1 {
+			t.Errorf("p%g: got %d, exact %d", percentile, got, exact)
+		}
+	}
+
+	var sum int64
+	for _, v := range values {
+		sum += v
+	}
+	if h.TotalCount != int64(len(values)) || h.Min != values[0] || h.Max != values[len(values)-1] || h.Sum != sum {
+		t.Errorf("got count %d min %d max %d sum %d, want %d %d %d %d", h.TotalCount, h.Min, h.Max, h.Sum,
+			len(values), values[0], values[len(values)-1], sum)
+	}
+}
+
+func TestHistogramHighestTrackable(t *testing.T) {
+	h := NewHistogram(testHighest, testDigits)
+	h.RecordValue(testHighest)
+	h.RecordValue(10 * testHighest)
+	h.RecordValue(-5)
+	if h.TotalCount != 3 || h.Max != testHighest || h.Min != 0 {
+		t.Fatalf("values not clamped: count %d min %d max %d", h.TotalCount, h.Min, h.Max)
+	}
+	if got := h.ValueAtPercentile(99); got != testHighest {
+		t.Errorf("p99 of clamped values: got %d, want %d", got, testHighest)
+	}
+	if got := h.ValueAtPercentile(10); got != 0 {
+		t.Errorf("p10 of clamped values: got %d, want 0", got)
+	}
+	if index := h.countsIndex(testHighest); index != len(h.Counts)-1 {
+		t.Errorf("highest trackable value in bucket %d of %d", index, len(h.Counts))
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, values := sampleHistogram(1000)
+	b, _ := sampleHistogram(1000)
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.TotalCount != 2*int64(len(values)) || a.ValueAtPercentile(50) != b.ValueAtPercentile(50) {
+		t.Errorf("merged histogram of count %d and p50 %d", a.TotalCount, a.ValueAtPercentile(50))
+	}
+	if err := a.Merge(NewHistogram(testHighest, testDigits+1)); err != nil {
+		t.Errorf("merging an empty histogram failed: %s", err)
+	}
+	other := NewHistogram(testHighest, testDigits+1)
+	other.RecordValue(1)
+	if err := a.Merge(other); err == nil {
+		t.Error("merged histograms of different precision")
+	}
+}
+
+func TestHistogramEncoding(t *testing.T) {
+	h, _ := sampleHistogram(1000)
+	for name, hist := range map[string]*Histogram{"sampled": h, "empty": NewHistogram(testHighest, testDigits)} {
+		data, err := json.Marshal(hist)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fromJson Histogram
+		if err := json.Unmarshal(data, &fromJson); err != nil || !reflect.DeepEqual(&fromJson, hist) {
+			t.Errorf("%s: JSON round trip failed: %v", name, err)
+		}
+
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(LatencyResult{Histogram: hist}); err != nil {
+			t.Fatal(err)
+		}
+		var fromGob LatencyResult
+		if err := gob.NewDecoder(&buf).Decode(&fromGob); err != nil || !reflect.DeepEqual(fromGob.Histogram, hist) {
+			t.Errorf("%s: gob round trip failed: %v", name, err)
+		}
+	}
+
+	// Only the buckets with a count are encoded
+	data, _ := json.Marshal(h)
+	var sparse sparseHistogram
+	if err := json.Unmarshal(data, &sparse); err != nil {
+		t.Fatal(err)
+	}
+	var buckets int
+	for _, c := range h.Counts {
+		if c != 0 {
+			buckets++
+		}
+	}
+	if len(sparse.Buckets) != buckets || len(sparse.Counts) != 0 {
+		t.Errorf("encoded %d buckets and %d counts, want %d buckets", len(sparse.Buckets), len(sparse.Counts), buckets)
+	}
+}
+
+func TestHistogramDecodeDense(t *testing.T) {
+	h, _ := sampleHistogram(1000)
+	// Documents written before the sparse encoding carry all counts
+	type dense Histogram
+	data, err := json.Marshal((*dense)(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Histogram
+	if err := json.Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(&decoded, h) {
+		t.Errorf("dense document not decoded: %v", err)
+	}
+}
+
+func TestHistogramDecodeInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{"HighestTrackable":0,"SignificantDigits":3}`,
+		`{"HighestTrackable":60000000,"SignificantDigits":9}`,
+		`{"HighestTrackable":60000000,"SignificantDigits":3,"Buckets":[[99999999,1]]}`,
+		`{"HighestTrackable":60000000,"SignificantDigits":3,"Counts":[1,2,3]}`,
+	} {
+		var h Histogram
+		if err := json.Unmarshal([]byte(data), &h); err == nil {
+			t.Errorf("decoded invalid histogram %s", data)
+		}
+	}
+}
@@ -6,6 +6,13 @@ type Orchestrator struct {
 }
 
 type Worker struct {
-	Worker string
-	IP     string
+	Worker      string
+	IP          string            // Primary pod IP
+	IPs         []string          // All pod IPs, one per address family on dual-stack clusters
+	ServiceName string            // Service fronting the worker pod
+	ServiceIPs  []string          // ClusterIPs of that Service, empty if they could not be resolved
+	HostNetwork bool              // Whether the worker pod runs in the network namespace of its node
+	NodeIPs     []string          // IPs of the node the worker pod runs on
+	NodePorts   map[string]string // NodePort of the NodePort Service fronting the worker, by server port
+	Fingerprint Fingerprint       // Environment the worker measures in
 }
@@ -0,0 +1,41 @@
+package types
+
+import "time"
+
+// RunSummary is the entry of a run in the catalogue of the results store
+type RunSummary struct {
+	ID       string
+	Started  time.Time
+	Finished time.Time
+	Tags     map[string]string // User supplied tags of the run, e.g. cni=calico
+	Points   int               // Number of data points of the run
+}
+
+// Run is a completed run as kept in the results store
+type Run struct {
+	RunSummary
+	Results []Result
+}
+
+// Manifest describes the files of the result directory of a run
+type Manifest struct {
+	Run     RunSummary
+	Csv     string                 // Name of the result csv
+	Json    string                 // Name of the result document
+	Jobs    []ManifestJob          // Every job of the schedule with its raw output file
+	Workers map[string]Fingerprint // Fingerprints of the registered workers, once the run completed
+}
+
+// ManifestJob is a single job of the schedule in the manifest of a run
+type ManifestJob struct {
+	Index      int
+	Label      string
+	Parameters string
+	Output     string // Raw output of all workers for the job, relative to the result directory
+}
+
+// Plan is the schedule a run executed together with the settings of the orchestrator it was derived from
+type Plan struct {
+	Settings  map[string]string // Environment variables of the orchestrator shaping the schedule
+	Testcases []*Testcase
+}
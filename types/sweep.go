@@ -0,0 +1,193 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the parameters a testcase can sweep through
+const (
+	ParamMSS          = "mss"
+	ParamDatagramSize = "datagram"
+	ParamStreams      = "streams"
+	ParamCongestion   = "congestion"
+	ParamRate         = "rate"
+	ParamDuration     = "duration"
+	ParamDirection    = "direction"
+	ParamBufferSize   = "buffer"
+	ParamResponseSize = "response"
+	ParamQPS          = "qps"
+	ParamKeepAlive    = "keepalive"
+	ParamHttpVersion  = "http"
+	ParamConnections  = "connections"
+	ParamDnsServer    = "dnsserver"
+	ParamDnsNames     = "names"
+	ParamFamily       = "family"
+)
+
+// Directions of the traffic between client and server
+const (
+	DirectionUpload   = "upload"   // Client sends to server, the default
+	DirectionDownload = "download" // Server sends to client (iperf3 -R)
+	DirectionBidir    = "bidir"    // Both at the same time (iperf3 --bidir)
+)
+
+// Values of the keep-alive and HTTP version parameters
+const (
+	KeepAliveOn  = "on"
+	KeepAliveOff = "off"
+	Http1        = "1.1"
+	Http2        = "2"
+)
+
+// Address families of a job
+const (
+	FamilyIPv4 = "4"
+	FamilyIPv6 = "6"
+)
+
+// Params is the full parameter tuple a single job is run with.
+// Zero values leave the tool defaults of the worker in place.
+type Params struct {
+	MSS          int    // TCP/SCTP maximum segment size (MTU - 40 bytes)
+	DatagramSize int    // UDP datagram size in bytes
+	Streams      int    // Number of parallel streams
+	Congestion   string // TCP congestion control algorithm, e.g. cubic or bbr
+	Rate         string // Target bitrate for UDP tests, e.g. 100M or 0 for unlimited
+	Duration     int    // Test duration in seconds
+	Direction    string // One of the Direction constants, upload if empty
+	BufferSize   int    // Size of a single write or request in bytes, native tests only
+	ResponseSize int    // Size of a HTTP response body in bytes
+	QPS          int    // Fixed rate of HTTP requests per second, closed loop if 0
+	KeepAlive    string // Whether HTTP connections are reused, on if empty
+	HttpVersion  string // HTTP protocol version, 1.1 if empty
+	Connections  int    // Number of concurrent connections held open by a connection scale test
+	DnsServer    string // Nameserver of a DNS test as host[:port], the nameserver of the worker if empty
+	DnsNames     string // Comma separated names a DNS test resolves, the destination if empty
+	Family       string // Address family of the destination address, the primary address if empty
+}
+
+// Dimension is a single parameter of a testcase sweep together with the values it steps through
+type Dimension struct {
+	Name   string
+	Values []string
+}
+
+// Job is a single run of a testcase with one parameter tuple of its sweep
+type Job struct {
+	Testcase int // Index of the testcase in the schedule
+	Params   Params
+	Finished bool
+	Address  string          // Host the client was sent to, set when the job is allocated
+	Assigned []bool          // Flows handed out to their source workers
+	Flows    []FlowResult    // Outputs of the flows of a multi-flow job as they come in
+	StartAt  time.Time       // Scheduled start of all flows of the job
+	Monitors []string        // Destination workers sampling their CPU for the job
+	Done     bool            // All outputs of the job came in
+	Cpu      []CpuUsage      // CPU usage of the workers of the job as they come in
+	IperfCpu *IperfCpu       // CPU utilization reported by iperf3
+	Counters []StackCounters // Counter deltas of the workers of the job as they come in
+}
+
+// Set assigns the value of the named parameter
+func (p *Params) Set(name, value string) (err error) {
+	switch name {
+	case ParamMSS:
+		p.MSS, err = strconv.Atoi(value)
+	case ParamDatagramSize:
+		p.DatagramSize, err = strconv.Atoi(value)
+	case ParamStreams:
+		p.Streams, err = strconv.Atoi(value)
+	case ParamCongestion:
+		p.Congestion = value
+	case ParamRate:
+		p.Rate = value
+	case ParamDuration:
+		p.Duration, err = strconv.Atoi(value)
+	case ParamDirection:
+		if value != DirectionUpload && value != DirectionDownload && value != DirectionBidir {
+			err = fmt.Errorf("unknown direction %q", value)
+		}
+		p.Direction = value
+	case ParamBufferSize:
+		p.BufferSize, err = strconv.Atoi(value)
+	case ParamResponseSize:
+		p.ResponseSize, err = strconv.Atoi(value)
+	case ParamQPS:
+		p.QPS, err = strconv.Atoi(value)
+	case ParamKeepAlive:
+		if value != KeepAliveOn && value != KeepAliveOff {
+			err = fmt.Errorf("unknown keep-alive setting %q", value)
+		}
+		p.KeepAlive = value
+	case ParamHttpVersion:
+		if value != Http1 && value != Http2 {
+			err = fmt.Errorf("unknown HTTP version %q", value)
+		}
+		p.HttpVersion = value
+	case ParamConnections:
+		p.Connections, err = strconv.Atoi(value)
+	case ParamDnsServer:
+		p.DnsServer = value
+	case ParamDnsNames:
+		p.DnsNames = value
+	case ParamFamily:
+		if value != FamilyIPv4 && value != FamilyIPv6 {
+			err = fmt.Errorf("unknown address family %q", value)
+		}
+		p.Family = value
+	default:
+		err = fmt.Errorf("unknown sweep parameter %q", name)
+	}
+	return
+}
+
+// Get returns the value of the named parameter
+func (p Params) Get(name string) string {
+	switch name {
+	case ParamMSS:
+		return strconv.Itoa(p.MSS)
+	case ParamDatagramSize:
+		return strconv.Itoa(p.DatagramSize)
+	case ParamStreams:
+		return strconv.Itoa(p.Streams)
+	case ParamCongestion:
+		return p.Congestion
+	case ParamRate:
+		return p.Rate
+	case ParamDuration:
+		return strconv.Itoa(p.Duration)
+	case ParamDirection:
+		return p.Direction
+	case ParamBufferSize:
+		return strconv.Itoa(p.BufferSize)
+	case ParamResponseSize:
+		return strconv.Itoa(p.ResponseSize)
+	case ParamQPS:
+		return strconv.Itoa(p.QPS)
+	case ParamKeepAlive:
+		return p.KeepAlive
+	case ParamHttpVersion:
+		return p.HttpVersion
+	case ParamConnections:
+		return strconv.Itoa(p.Connections)
+	case ParamDnsServer:
+		return p.DnsServer
+	case ParamDnsNames:
+		return p.DnsNames
+	case ParamFamily:
+		return p.Family
+	}
+	return ""
+}
+
+// Format renders the values of the given dimensions, e.g. "mss=96 streams=8"
+func (p Params) Format(dimensions []Dimension) string {
+	var parts []string
+	for _, d := range dimensions {
+		parts = append(parts, d.Name+"="+p.Get(d.Name))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,43 @@
+package types
+
+// StreamResult is the outcome of a single stream of the native throughput engine
+type StreamResult struct {
+	Bytes    int64   // Bytes received by the server
+	Sent     int64   // Datagrams sent by the client, UDP only
+	Received int64   // Datagrams received by the server, UDP only
+	Jitter   float64 // Receiver jitter in ms, UDP only
+	Mbps     float64
+	Error    string
+}
+
+// ThroughputResult is the outcome of a native throughput test as reported by the client
+type ThroughputResult struct {
+	Protocol    string
+	Duration    float64 // Seconds
+	Bytes       int64
+	Mbps        float64
+	LossPercent float64 // UDP only
+	Jitter      float64 // Mean receiver jitter of all streams in ms, UDP only
+	Streams     []StreamResult
+}
+
+// LatencyResult is the outcome of a native request/response test as reported by the client
+type LatencyResult struct {
+	Protocol  string
+	Duration  float64 // Seconds
+	Requests  int64   // Round trips completed
+	Errors    int64   // Round trips failed or timed out
+	Bytes     int64   // Response payload received, HTTP only
+	Histogram *Histogram
+}
+
+// DnsResult breaks down the lookups of a DNS test, their latencies are reported as LatencyResult
+type DnsResult struct {
+	Server       string              // Nameserver queried, empty for the nameserver of the worker
+	Lookups      int64               // Lookups issued
+	NXDomain     int64               // Lookups answered with NXDOMAIN
+	Timeouts     int64               // Lookups without answer in time
+	Failures     int64               // Lookups failed for any other reason
+	Inconsistent int64               // Lookups answered differently than the first lookup of the same name
+	Answers      map[string][]string // Distinct answers per name, addresses sorted and comma separated
+}
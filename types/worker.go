@@ -1,11 +1,17 @@
 package types
 
+import "time"
+
 // IperfClientWorkItem represents a single task for an Iperf client
 type IperfClientWorkItem struct {
-	Host string
-	Port string
-	MSS  int // TCP/SCTP maximum segment size (MTU - 40 bytes)
-	Type int
+	Host    string
+	Port    string
+	Params  Params
+	Type    int
+	Job     int       // Index of the job in the schedule, reported back with the output
+	Flow    int       // Index of the flow within a multi-flow job
+	Start   string    // One of the Start constants, immediately if empty
+	StartAt time.Time // Wall clock time a scheduled start waits for
 }
 
 // IperfServerWorkItem represents a single task for an Iperf server
@@ -14,28 +20,77 @@ type IperfServerWorkItem struct {
 	Timeout    int
 }
 
+// MonitorWorkItem asks the destination worker of a job to sample its CPU until the job is done
+type MonitorWorkItem struct {
+	Job     int
+	Start   string    // Start of the job, a scheduled start is waited for before sampling
+	StartAt time.Time // Wall clock time of a scheduled start
+}
+
+// PreflightTarget is a server port a source worker has to reach for its testcases
+type PreflightTarget struct {
+	Destination string
+	Host        string
+	Port        string
+}
+
+// PreflightWorkItem asks a worker to check its tools and servers and to reach the servers of its destinations
+type PreflightWorkItem struct {
+	Targets []PreflightTarget
+}
+
+// PreflightReport lists the problems a worker found during the pre-flight checks, empty if all passed
+type PreflightReport struct {
+	Worker   string
+	Problems []string
+}
+
+// WorkRequest asks the orchestrator for the next work item of a worker
+type WorkRequest struct {
+	Worker    string
+	Keepalive int // Seconds the request blocks at most while there is no work, it returns idle afterwards
+}
+
 // WorkItem represents a single task for a worker
 type WorkItem struct {
-	IsClientItem bool
-	IsServerItem bool
-	IsIdle       bool
-	ClientItem   IperfClientWorkItem
-	ServerItem   IperfServerWorkItem
+	IsClientItem    bool
+	IsServerItem    bool
+	IsIdle          bool
+	IsMonitorItem   bool
+	IsPreflightItem bool
+	ClientItem      IperfClientWorkItem
+	ClientItems     []IperfClientWorkItem // Further flows of a multi-flow job, run concurrently with ClientItem
+	ServerItem      IperfServerWorkItem
+	MonitorItem     MonitorWorkItem
+	PreflightItem   PreflightWorkItem
 }
 
 type WorkerState struct {
 	SentServerItem bool
 	Idle           bool
 	IP             string
+	IPs            []string
 	Worker         string
+	ServiceName    string
+	ServiceIPs     []string
+	HostNetwork    bool
+	NodeIPs        []string
+	NodePorts      map[string]string
+	Fingerprint    Fingerprint
 }
 
 // WorkerOutput stores the results from a single worker
 type WorkerOutput struct {
-	Output string
-	Code   int
-	Worker string
-	Type   int
+	Output     string
+	Code       int
+	Worker     string
+	Type       int
+	Job        int               // Index of the job the output belongs to
+	Flow       int               // Index of the flow within a multi-flow job
+	StartedAt  time.Time         // Wall clock time the client actually started
+	Throughput *ThroughputResult // Structured result of the native throughput engine
+	Latency    *LatencyResult    // Structured result of the native latency tester
+	Dns        *DnsResult        // Lookup outcomes of a DNS test, latencies are in Latency
 }
 
 type Testcase struct {
@@ -43,7 +98,33 @@ type Testcase struct {
 	DestinationNode string
 	Label           string
 	ClusterIP       bool
-	Finished        bool
-	MSS             int
+	NodePort        bool   // Target the NodePort of the destination on its node IP instead of its Pod IP
+	External        bool   // DestinationNode is the host[:port] of a server outside the cluster instead of a worker
+	Flows           []Flow // Concurrent flows of an incast or fan-out testcase, SourceNode and DestinationNode are unused
+	Start           string // How the flows of a multi-flow job are started together, one of the Start constants
 	Type            int
+	Params          Params      // Fixed parameters the sweep is applied on
+	Sweep           []Dimension // Parameters whose cartesian product is expanded into jobs
+	LossThreshold   float64     // Maximum loss in percent for a rate to count as sustained
+	Repetitions     int         // Runs of every parameter tuple, latency histograms of all runs are merged
+	ServiceAddress  string      // Host of Virtual IP testcases, the ClusterIP registered by the destination if empty
+}
+
+// Ways the flows of a multi-flow job are started together
+const (
+	StartScheduled = "scheduled" // All flows wait for a common wall clock time, requires synchronized clocks
+	StartBarrier   = "barrier"   // All flows wait at a barrier of the orchestrator which releases them at once
+)
+
+// BarrierRequest announces a flow waiting at the start barrier of its job
+type BarrierRequest struct {
+	Worker string
+	Job    int
+	Flow   int
+}
+
+// Flow is a single source to destination pair of a multi-flow testcase
+type Flow struct {
+	SourceNode      string
+	DestinationNode string
 }